@@ -0,0 +1,27 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDir returns a temp directory containing the given files, keyed by relative path, with the given content
+func newTestDir(t *testing.T, contents map[string]string) string {
+	root := t.TempDir()
+	for file, content := range contents {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// newWalkTestDir returns a temp directory containing the tree used by the walk tests
+func newWalkTestDir(t *testing.T) string {
+	return newTestDir(t, map[string]string{"a.sp": "", "b.txt": "", "d1/c.sp": "", "d1/d2/d.sp": ""})
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -36,8 +36,20 @@ type ListOptions struct {
 	Include []string
 	Exclude []string
 	Flags   ListFlag
-	// max results
+	// max results - this only applies to recursive listings
 	MaxResults int
+	// the maximum depth to recurse to - children of the list path have a depth of 1
+	// if not set, there is no limit
+	MaxDepth int
+	// if set, symlinks to directories are followed when recursing, and are listed as directories
+	// entries beneath a followed symlink are listed under the symlink path
+	// symlinks which would create a cycle are not followed, and are listed as if FollowSymlinks was not set
+	FollowSymlinks bool
+	// if greater than 1, a recursive listing reads up to this many directories in parallel
+	// the results are then in no particular order (see Sort), and with MaxResults, which results are returned may vary
+	Concurrency int
+	// if set, the results of ListFiles and ListEntries are sorted by path
+	Sort bool
 }
 
 // ListFiles returns path of files and or folders under listPath
@@ -47,24 +59,52 @@ func ListFiles(listPath string, opts *ListOptions) ([]string, error) {
 }
 
 func ListFilesWithContext(ctx context.Context, listPath string, opts *ListOptions) ([]string, error) {
+	entries, err := ListEntries(ctx, listPath, opts)
+	var res []string
+	for _, entry := range entries {
+		res = append(res, entry.Path)
+	}
+	return res, err
+}
+
+// ListEntries returns the files and or folders under listPath, with their metadata
+// inclusions/exclusions/recursion is defined by opts, as for ListFiles
+func ListEntries(ctx context.Context, listPath string, opts *ListOptions) ([]Entry, error) {
+	var res []Entry
+	err := Walk(ctx, listPath, opts, func(entry Entry) error {
+		res = append(res, entry)
+		return nil
+	})
+	if opts != nil && opts.Sort {
+		sort.Slice(res, func(i, j int) bool { return res[i].Path < res[j].Path })
+	}
+	return res, err
+}
+
+// resolveListOptions checks the list path exists, and applies the defaults to a copy of the list options
+// if the list path is a file, the returned list path is its parent directory, and the file name becomes the filter
+func resolveListOptions(listPath string, opts *ListOptions) (string, *ListOptions, bool, error) {
 	// check folder exists
 	if _, err := os.Stat(listPath); os.IsNotExist(err) {
-		return nil, nil
+		return "", nil, false, nil
 	}
 	if opts == nil {
 		opts = &ListOptions{Flags: Files & Directories & Recursive}
 	}
+	// copy the options, as they may be modified
+	resolved := *opts
+	opts = &resolved
 
 	//check if the listPath is the path to a file in the system
 	if FileExists(listPath) {
 		// if we are not listing files with a path to a file
 		if opts.Flags&Files == 0 {
 			// it's an error
-			return nil, fmt.Errorf("if the path is a file, then you must set the Files ListFlag")
+			return "", nil, false, fmt.Errorf("if the path is a file, then you must set the Files ListFlag")
 		}
 		// there should not be an include
 		if len(opts.Include)+len(opts.Exclude) > 0 {
-			return nil, fmt.Errorf("if the path is a file, then you must not specify include/exclude")
+			return "", nil, false, fmt.Errorf("if the path is a file, then you must not specify include/exclude")
 		}
 		// split up into the parent directory and the file name
 		dir := filepath.Dir(listPath)
@@ -73,11 +113,7 @@ func ListFilesWithContext(ctx context.Context, listPath string, opts *ListOption
 		// and the file name becomes the filter
 		opts.Include = []string{listPath}
 	}
-
-	if opts.Flags&Recursive != 0 {
-		return listFilesRecursive(ctx, listPath, opts)
-	}
-	return listFilesFlat(ctx, listPath, opts)
+	return listPath, opts, true, nil
 }
 
 // InclusionsFromExtensions takes a list of file extensions and convert into a .gitgnore format inclusions list
@@ -124,11 +160,23 @@ func ShouldIncludePath(path string, include, exclude []string) bool {
 	return false
 }
 
-func listFilesRecursive(ctx context.Context, listPath string, opts *ListOptions) ([]string, error) {
-	var res []string
-	count := 0 // initialize a counter to keep track of the number of files returned
-	err := filepath.WalkDir(listPath,
-		func(filePath string, entry fs.DirEntry, err error) error {
+// walkRecursive passes each entry under listPath which should be included to found, recursing into directories
+func walkRecursive(ctx context.Context, listPath string, opts *ListOptions, found func(Entry) error) error {
+	err := walkTree(ctx, listPath, listPath, listPath, []string{realPath(listPath)}, opts, found)
+	// skipAll sends a nil error in case of context cancellation - we want to capture the context cancellation error
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// walkTree walks the directory walkRoot, passing each entry which should be included to found
+// entries are reported under displayRoot, which differs from walkRoot when walking the target of a followed symlink
+// followed contains the real paths of the list path and the targets of the symlinks followed to reach walkRoot,
+// and is used to detect cycles
+func walkTree(ctx context.Context, listPath, walkRoot, displayRoot string, followed []string, opts *ListOptions, found func(Entry) error) error {
+	return filepath.WalkDir(walkRoot,
+		func(walkPath string, entry fs.DirEntry, err error) error {
 			// handle context cancellations
 			if ctx.Err() != nil {
 				log.Println("[INFO] context canceled")
@@ -145,60 +193,148 @@ func listFilesRecursive(ctx context.Context, listPath string, opts *ListOptions)
 				}
 				return err
 			}
-			// ignore list path itself
-			if filePath == listPath {
+			// ignore walk root itself
+			if walkPath == walkRoot {
 				return nil
 			}
-			// should we include this file?
-			if shouldIncludeEntry(listPath, filePath, entry, opts) {
-				res = append(res, filePath)
-				count++ // increment the counter
-				// check the number of files reached, if MaxResults is reached,
-				// stop walking the directory
-				if opts.MaxResults > 0 {
-					if count == opts.MaxResults {
-						// TODO: go 1.20 has a fs.SkipAll error which we can use
-						return io.EOF
-					}
+			filePath := displayRoot + walkPath[len(walkRoot):]
+
+			v := visitEntry(listPath, walkPath, filePath, entry, followed, opts)
+			if v.include {
+				if err := found(newEntry(listPath, filePath, v.entry)); err != nil {
+					return err
+				}
+			}
+			if v.symlinkTarget != "" {
+				if !v.descend {
+					// the symlink is not a directory to WalkDir, so do not return SkipDir (which would skip its siblings)
+					return nil
 				}
-			} else if entry.IsDir() && !shouldSearchInDir(listPath, filePath, opts) {
+				// walk the target of the symlink, listing its entries under the symlink path
+				return walkTree(ctx, listPath, v.symlinkTarget, filePath, append(followed, v.symlinkTarget), opts, found)
+			}
+			if entry.IsDir() && !v.descend {
 				return fs.SkipDir
 			}
 			return nil
 		})
-	// set err to nil in case of EOF error so that the code doesn't exit from here
-	if err == io.EOF {
-		err = nil
+}
+
+// visit is the outcome of visiting an entry of a recursive walk
+type visit struct {
+	// the entry - for a followed symlink, this is an entry for the target directory
+	entry fs.DirEntry
+	// should the entry be included in the results
+	include bool
+	// should the walk descend into the entry (only set for directories)
+	descend bool
+	// the real path of the target directory of a followed symlink
+	symlinkTarget string
+}
+
+// visitEntry decides whether an entry of a recursive walk is included in the results, and whether to descend into it
+// walkPath is the real location of the entry, and filePath the path it is listed under
+func visitEntry(listPath, walkPath, filePath string, entry fs.DirEntry, followed []string, opts *ListOptions) visit {
+	v := visit{entry: entry}
+	depth := pathDepth(listPath, filePath)
+
+	// if we are following symlinks, resolve symlinks to directories
+	// (symlinks which would create a cycle are treated as if we are not following symlinks)
+	if opts.FollowSymlinks && entry.Type()&fs.ModeSymlink != 0 {
+		if target, targetEntry, ok := resolveSymlinkDir(walkPath); ok && !isSymlinkCycle(walkPath, target, followed) {
+			v.symlinkTarget = target
+			v.entry = targetEntry
+		}
 	}
-	// skipAll sends a nil error in case of context cancellation - we want to capture the context cancellation error
-	if err == nil {
-		err = ctx.Err()
+
+	// should we include this file?
+	v.include = shouldIncludeEntry(listPath, filePath, v.entry, opts) && (opts.MaxDepth == 0 || depth <= opts.MaxDepth)
+	// should we search in this directory?
+	v.descend = v.entry.IsDir() &&
+		(opts.MaxDepth == 0 || depth < opts.MaxDepth) &&
+		(v.include || shouldSearchInDir(listPath, filePath, opts))
+	return v
+}
+
+// resolveSymlinkDir returns the real path and a directory entry for the target of a symlink, if it is a directory
+func resolveSymlinkDir(path string) (string, fs.DirEntry, bool) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		// a broken symlink
+		return "", nil, false
 	}
-	return res, err
+	info, err := os.Stat(target)
+	if err != nil || !info.IsDir() {
+		return "", nil, false
+	}
+	return target, fs.FileInfoToDirEntry(&namedFileInfo{FileInfo: info, name: filepath.Base(path)}), true
 }
 
-func listFilesFlat(ctx context.Context, listPath string, opts *ListOptions) ([]string, error) {
+// isSymlinkCycle returns whether following the symlink at path to target would revisit a directory
+// which is being walked - i.e. the target is an ancestor of the symlink, or has already been followed
+func isSymlinkCycle(path, target string, followed []string) bool {
+	parent := realPath(filepath.Dir(path))
+	if parent == target || strings.HasPrefix(parent, target+string(os.PathSeparator)) {
+		return true
+	}
+	for _, f := range followed {
+		if f == target {
+			return true
+		}
+	}
+	return false
+}
 
+// realPath returns the path with all symlinks resolved, or the path itself if it cannot be resolved
+func realPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// pathDepth returns the depth of filePath beneath listPath - children of listPath have a depth of 1
+func pathDepth(listPath, filePath string) int {
+	return len(SplitPath(relativePath(listPath, filePath)))
+}
+
+// namedFileInfo is a fs.FileInfo with a different name - used for the target of a symlink,
+// so it has the name of the symlink
+type namedFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i *namedFileInfo) Name() string {
+	return i.name
+}
+
+// walkFlat passes each entry in listPath which should be included to found
+func walkFlat(ctx context.Context, listPath string, opts *ListOptions, found func(Entry) error) error {
 	entries, err := os.ReadDir(listPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read folder %s: %v", listPath, err)
+		return fmt.Errorf("failed to read folder %s: %v", listPath, err)
 	}
 
-	var matches []string
 	for _, entry := range entries {
 		// handle context cancellations
 		if ctx.Err() != nil {
-			return nil, ctx.Err()
+			return ctx.Err()
 		}
 		filePath := filepath.Join(listPath, entry.Name())
-		if err != nil {
-			continue
+		// if we are following symlinks, list symlinks to directories as directories
+		if opts.FollowSymlinks && entry.Type()&fs.ModeSymlink != 0 {
+			if _, targetEntry, ok := resolveSymlinkDir(filePath); ok {
+				entry = targetEntry
+			}
 		}
 		if shouldIncludeEntry(listPath, filePath, entry, opts) {
-			matches = append(matches, filePath)
+			if err := found(newEntry(listPath, filePath, entry)); err != nil {
+				return err
+			}
 		}
 	}
-	return matches, nil
+	return nil
 }
 
 // should the list results include this entry, based on the list options
@@ -1,6 +1,8 @@
 package files
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -470,3 +472,192 @@ func TestListFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestListFilesDepthAndSymlinks(t *testing.T) {
+	// root
+	// ├── a.sp
+	// ├── d1/b.sp
+	// ├── d1/d2/c.sp
+	// ├── link -> d1/d2 (followed)
+	// ├── d1/d2/loop -> d1 (a cycle, not followed)
+	// └── z.sp
+	root := newTestDir(t, map[string]string{"a.sp": "", "d1/b.sp": "", "d1/d2/c.sp": "", "z.sp": ""})
+	if err := os.Symlink(filepath.Join(root, "d1", "d2"), filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "d1"), filepath.Join(root, "d1", "d2", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		options  *ListOptions
+		expected []string
+	}{
+		"files max depth 1": {
+			options:  &ListOptions{Flags: FilesRecursive, Include: []string{"**/*.sp"}, MaxDepth: 1},
+			expected: []string{"a.sp", "z.sp"},
+		},
+		"files max depth 2": {
+			options:  &ListOptions{Flags: FilesRecursive, Include: []string{"**/*.sp"}, MaxDepth: 2},
+			expected: []string{"a.sp", "d1/b.sp", "z.sp"},
+		},
+		"files without following symlinks": {
+			options:  &ListOptions{Flags: FilesRecursive, Include: []string{"**/*.sp"}},
+			expected: []string{"a.sp", "d1/b.sp", "d1/d2/c.sp", "z.sp"},
+		},
+		"files following symlinks": {
+			options:  &ListOptions{Flags: FilesRecursive, Include: []string{"**/*.sp"}, FollowSymlinks: true},
+			expected: []string{"a.sp", "d1/b.sp", "d1/d2/c.sp", "link/c.sp", "z.sp"},
+		},
+		"files following symlinks max depth 1": {
+			// the entries after a symlink which is not descended into are still listed
+			options:  &ListOptions{Flags: FilesRecursive, Include: []string{"**/*.sp"}, FollowSymlinks: true, MaxDepth: 1},
+			expected: []string{"a.sp", "z.sp"},
+		},
+		"directories following symlinks": {
+			options:  &ListOptions{Flags: DirectoriesRecursive, FollowSymlinks: true, MaxDepth: 2},
+			expected: []string{"d1", "d1/d2", "link"},
+		},
+		"directories flat following symlinks": {
+			options:  &ListOptions{Flags: DirectoriesFlat, FollowSymlinks: true},
+			expected: []string{"d1", "link"},
+		},
+	}
+	for name, test := range tests {
+		files, err := ListFiles(root, test.options)
+		if err != nil {
+			t.Errorf("Test: '%s'' FAILED with unexpected error: %v", name, err)
+			continue
+		}
+		for i, f := range files {
+			rel, err := filepath.Rel(root, f)
+			if err != nil {
+				t.Errorf("failed to convert %s to a relative path for verification: %v", f, err)
+			}
+			files[i] = filepath.ToSlash(rel)
+		}
+		sort.Strings(files)
+		if !reflect.DeepEqual(test.expected, files) {
+			t.Errorf("Test: '%s'' FAILED : expected:\n\n%s\n\ngot:\n\n%s", name, test.expected, files)
+		}
+	}
+}
+
+func TestListFilesConcurrency(t *testing.T) {
+	// build a tree with enough directories for the workers to run in parallel
+	contents := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 5; j++ {
+			for _, file := range []string{"a.sp", "b.txt", "leaf/c.sp"} {
+				contents[fmt.Sprintf("d%d/d%d/%s", i, j, file)] = ""
+			}
+		}
+	}
+	root := newTestDir(t, contents)
+	if err := os.Symlink(filepath.Join(root, "d0"), filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	tests := map[string]*ListOptions{
+		"files":                     {Flags: FilesRecursive},
+		"include":                   {Flags: FilesRecursive, Include: []string{"d1/**/*.sp"}},
+		"exclude":                   {Flags: AllRecursive, Exclude: []string{"**/leaf"}},
+		"directories":               {Flags: DirectoriesRecursive},
+		"max depth":                 {Flags: AllRecursive, MaxDepth: 2},
+		"follow symlinks":           {Flags: FilesRecursive, FollowSymlinks: true},
+		"follow symlinks max depth": {Flags: AllRecursive, FollowSymlinks: true, MaxDepth: 3},
+	}
+	for name, opts := range tests {
+		expected, err := ListFiles(root, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(expected)
+
+		parallelOpts := *opts
+		parallelOpts.Concurrency = 4
+		parallelOpts.Sort = true
+		files, err := ListFiles(root, &parallelOpts)
+		if err != nil {
+			t.Errorf("Test: '%s'' FAILED with unexpected error: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(expected, files) {
+			t.Errorf("Test: '%s'' FAILED : expected:\n\n%s\n\ngot:\n\n%s", name, expected, files)
+		}
+	}
+
+	// max results
+	files, err := ListFiles(root, &ListOptions{Flags: FilesRecursive, Concurrency: 4, MaxResults: 10})
+	if err != nil || len(files) != 10 {
+		t.Errorf("expected 10 results, got %d (%v)", len(files), err)
+	}
+
+	// cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ListFilesWithContext(ctx, root, &ListOptions{Flags: FilesRecursive, Concurrency: 4}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestListEntries(t *testing.T) {
+	root := newTestDir(t, map[string]string{"b.sp": "bb", "d1/d2/a.sp": "a"})
+
+	type entrySummary struct {
+		relativePath string
+		depth        int
+		isDir        bool
+		size         int64
+	}
+	tests := map[string]struct {
+		options  *ListOptions
+		expected []entrySummary
+	}{
+		"recursive": {
+			options: &ListOptions{Flags: AllRecursive},
+			expected: []entrySummary{
+				{relativePath: "b.sp", depth: 1, size: 2},
+				{relativePath: "d1", depth: 1, isDir: true},
+				{relativePath: "d1/d2", depth: 2, isDir: true},
+				{relativePath: "d1/d2/a.sp", depth: 3, size: 1},
+			},
+		},
+		"sorted parallel": {
+			options: &ListOptions{Flags: FilesRecursive, Concurrency: 2, Sort: true},
+			expected: []entrySummary{
+				{relativePath: "b.sp", depth: 1, size: 2},
+				{relativePath: "d1/d2/a.sp", depth: 3, size: 1},
+			},
+		},
+	}
+	for name, test := range tests {
+		entries, err := ListEntries(context.Background(), root, test.options)
+		if err != nil {
+			t.Errorf("Test: '%s'' FAILED with unexpected error: %v", name, err)
+			continue
+		}
+		var summaries []entrySummary
+		for _, entry := range entries {
+			if entry.Path != filepath.Join(root, entry.RelativePath) {
+				t.Errorf("Test: '%s'' FAILED : path %s does not match relative path %s", name, entry.Path, entry.RelativePath)
+			}
+			info, err := entry.Info()
+			if err != nil {
+				t.Errorf("Test: '%s'' FAILED : failed to get info for %s: %v", name, entry.Path, err)
+				continue
+			}
+			s := entrySummary{relativePath: filepath.ToSlash(entry.RelativePath), depth: entry.Depth, isDir: entry.IsDir()}
+			if info.Mode().IsRegular() {
+				s.size = info.Size()
+			}
+			if info.IsDir() != entry.IsDir() {
+				t.Errorf("Test: '%s'' FAILED : info and type of %s do not match", name, entry.Path)
+			}
+			summaries = append(summaries, s)
+		}
+		if !reflect.DeepEqual(test.expected, summaries) {
+			t.Errorf("Test: '%s'' FAILED : expected:\n\n%v\n\ngot:\n\n%v", name, test.expected, summaries)
+		}
+	}
+}
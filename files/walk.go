@@ -0,0 +1,126 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sync"
+)
+
+// errStopWalk is returned by the walk callbacks to stop the walk without an error
+// (fs.SkipAll cannot be used, as it only stops the filepath.WalkDir which returns it, not the walk of a followed symlink)
+var errStopWalk = errors.New("stop walk")
+
+// errSkipDir is returned by Walk if the callback returns fs.SkipDir
+var errSkipDir = errors.New("fs.SkipDir is not supported by Walk - use Exclude to skip directories")
+
+// Entry is a file or directory found by Walk or ListEntries
+type Entry struct {
+	// the path of the entry - for entries beneath a followed symlink, this is the path under the symlink
+	Path string
+	// the path of the entry relative to the list root
+	RelativePath string
+	// the type bits of the entry (see fs.FileMode.Type) - for a followed symlink, this is the type of its target
+	Type fs.FileMode
+	// the depth of the entry below the list root - children of the root have a depth of 1
+	Depth int
+
+	info *lazyInfo
+}
+
+func newEntry(listPath, filePath string, entry fs.DirEntry) Entry {
+	rel := relativePath(listPath, filePath)
+	return Entry{
+		Path:         filePath,
+		RelativePath: rel,
+		Type:         entry.Type(),
+		Depth:        len(SplitPath(rel)),
+		info:         &lazyInfo{entry: entry},
+	}
+}
+
+// IsDir returns whether the entry is a directory
+func (e Entry) IsDir() bool {
+	return e.Type.IsDir()
+}
+
+// Info returns the fs.FileInfo of the entry
+// the info is loaded on first use (which usually requires a stat call), and cached - copies of the entry share the cache
+// as for fs.DirEntry, the info of a symlink which is not followed describes the link itself
+func (e Entry) Info() (fs.FileInfo, error) {
+	if e.info == nil {
+		return nil, fs.ErrInvalid
+	}
+	return e.info.load()
+}
+
+// lazyInfo loads the fs.FileInfo of a directory entry on first use
+type lazyInfo struct {
+	entry fs.DirEntry
+	once  sync.Once
+	info  fs.FileInfo
+	err   error
+}
+
+func (i *lazyInfo) load() (fs.FileInfo, error) {
+	i.once.Do(func() {
+		i.info, i.err = i.entry.Info()
+	})
+	return i.info, i.err
+}
+
+// relativePath returns filePath relative to listPath
+func relativePath(listPath, filePath string) string {
+	if rel, err := filepath.Rel(listPath, filePath); err == nil {
+		return rel
+	}
+	return filePath
+}
+
+// Walk calls fn for each file and or folder under root, as they are found, rather than building the full list
+// inclusions/exclusions/recursion and MaxResults are defined by opts, as for ListFiles
+// if fn returns an error, the walk stops and the error is returned - return fs.SkipAll to stop the walk without an error
+// fs.SkipDir is not supported, as with Concurrency a directory may be read before fn is called for it - if fn returns
+// fs.SkipDir, the walk stops with an error, whatever the options
+// if ctx is cancelled, the walk stops and ctx.Err() is returned
+// fn is never called concurrently - with Concurrency, directories are read in parallel, but the entries are passed
+// to fn one at a time, in no particular order
+func Walk(ctx context.Context, root string, opts *ListOptions, fn func(Entry) error) error {
+	root, opts, exists, err := resolveListOptions(root, opts)
+	if err != nil || !exists {
+		return err
+	}
+
+	count := 0
+	found := func(entry Entry) error {
+		if err := fn(entry); err != nil {
+			if err == fs.SkipAll {
+				return errStopWalk
+			}
+			if err == fs.SkipDir {
+				return errSkipDir
+			}
+			return err
+		}
+		count++
+		// stop the walk once MaxResults is reached (as for ListFiles, this only applies to recursive listings)
+		if opts.MaxResults > 0 && opts.Flags&Recursive != 0 && count == opts.MaxResults {
+			return errStopWalk
+		}
+		return nil
+	}
+
+	switch {
+	case opts.Flags&Recursive != 0 && opts.Concurrency > 1:
+		err = walkParallel(ctx, root, opts, found)
+	case opts.Flags&Recursive != 0:
+		err = walkRecursive(ctx, root, opts, found)
+	default:
+		err = walkFlat(ctx, root, opts, found)
+	}
+	if err == errStopWalk {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package files
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dirTask is a directory to be read by a parallel walk
+type dirTask struct {
+	// the real location of the directory, and the path its entries are listed under
+	walkPath    string
+	displayPath string
+	// the real paths of the list path and the targets of the symlinks followed to reach the directory
+	followed []string
+}
+
+// dirQueue is the queue of directories waiting to be read by the workers of a parallel walk
+type dirQueue struct {
+	lock  sync.Mutex
+	cond  *sync.Cond
+	tasks []dirTask
+	// the number of directories which are queued or being read - the walk is complete when this reaches 0
+	pending int
+	stopped bool
+}
+
+func newDirQueue() *dirQueue {
+	q := &dirQueue{}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+func (q *dirQueue) push(task dirTask) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.stopped {
+		return
+	}
+	q.tasks = append(q.tasks, task)
+	q.pending++
+	q.cond.Signal()
+}
+
+// pop waits for a directory to read - it returns false once the walk is complete or stopped
+func (q *dirQueue) pop() (dirTask, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for len(q.tasks) == 0 && q.pending > 0 && !q.stopped {
+		q.cond.Wait()
+	}
+	if q.stopped || len(q.tasks) == 0 {
+		return dirTask{}, false
+	}
+	// take the most recently queued directory, so the walk is depth first and the queue stays small
+	task := q.tasks[len(q.tasks)-1]
+	q.tasks = q.tasks[:len(q.tasks)-1]
+	return task, true
+}
+
+// done marks a directory returned by pop as read
+func (q *dirQueue) done() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.pending--
+	if q.pending == 0 {
+		q.cond.Broadcast()
+	}
+}
+
+func (q *dirQueue) stop() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.stopped = true
+	q.cond.Broadcast()
+}
+
+// walkParallel passes each entry under listPath which should be included to found, reading up to
+// opts.Concurrency directories in parallel
+// found is called from a single goroutine, and the walk stops if it returns an error
+func walkParallel(ctx context.Context, listPath string, opts *ListOptions, found func(Entry) error) error {
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := newDirQueue()
+	// stop the workers if the walk is cancelled
+	stopQueue := context.AfterFunc(walkCtx, queue.stop)
+	defer stopQueue()
+	// the root must be queued before the workers start, or they would find the walk complete
+	queue.push(dirTask{walkPath: listPath, displayPath: listPath, followed: []string{realPath(listPath)}})
+
+	results := make(chan Entry, opts.Concurrency)
+	var workers sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				task, ok := queue.pop()
+				if !ok {
+					return
+				}
+				readDirTask(walkCtx, listPath, task, opts, queue, results)
+				queue.done()
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	var err error
+	for entry := range results {
+		// once the walk has stopped, drain the results until the workers exit
+		if err != nil {
+			continue
+		}
+		if err = found(entry); err != nil {
+			cancel()
+		}
+	}
+	// a cancelled walk is not an error unless the parent context was cancelled
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// readDirTask reads a directory, sending the entries which should be included to results,
+// and queueing the child directories which should be searched
+func readDirTask(ctx context.Context, listPath string, task dirTask, opts *ListOptions, queue *dirQueue, results chan<- Entry) {
+	entries, err := os.ReadDir(task.walkPath)
+	if err != nil {
+		// ignore read errors - the directory may have been removed during the walk
+		return
+	}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		walkPath := filepath.Join(task.walkPath, entry.Name())
+		filePath := filepath.Join(task.displayPath, entry.Name())
+
+		v := visitEntry(listPath, walkPath, filePath, entry, task.followed, opts)
+		if v.include {
+			select {
+			case results <- newEntry(listPath, filePath, v.entry):
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !v.descend {
+			continue
+		}
+		child := dirTask{walkPath: walkPath, displayPath: filePath, followed: task.followed}
+		if v.symlinkTarget != "" {
+			// walk the target of the symlink, listing its entries under the symlink path
+			// (the followed paths are shared with other tasks, so are copied rather than appended to)
+			child.walkPath = v.symlinkTarget
+			child.followed = append(append([]string{}, task.followed...), v.symlinkTarget)
+		}
+		queue.push(child)
+	}
+}
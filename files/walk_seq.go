@@ -0,0 +1,29 @@
+//go:build go1.23
+
+package files
+
+import (
+	"context"
+	"io/fs"
+	"iter"
+)
+
+// WalkSeq returns an iterator over the files and or folders under root - see Walk
+// if the walk fails, the error is yielded with an empty Entry, and the iteration ends
+// breaking out of the loop stops the walk
+func WalkSeq(ctx context.Context, root string, opts *ListOptions) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		stopped := false
+		err := Walk(ctx, root, opts, func(entry Entry) error {
+			if !yield(entry, nil) {
+				stopped = true
+				return fs.SkipAll
+			}
+			return nil
+		})
+		// yield must not be called again once it has returned false
+		if err != nil && !stopped {
+			yield(Entry{}, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+//go:build go1.23
+
+package files
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWalkSeq(t *testing.T) {
+	root := newWalkTestDir(t)
+
+	// breaking out of the loop stops the walk
+	var paths []string
+	for entry, err := range WalkSeq(context.Background(), root, &ListOptions{Flags: FilesRecursive}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, entry.Path)
+		if len(paths) == 2 {
+			break
+		}
+	}
+	expected := []string{filepath.Join(root, "a.sp"), filepath.Join(root, "b.txt")}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Errorf("expected %s, got %s", expected, paths)
+	}
+
+	// errors are yielded
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var errs []error
+	for _, err := range WalkSeq(ctx, root, &ListOptions{Flags: FilesRecursive}) {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Errorf("expected a single context.Canceled error, got %v", errs)
+	}
+}
@@ -0,0 +1,101 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	root := newWalkTestDir(t)
+	errStop := errors.New("stop")
+
+	tests := map[string]struct {
+		options *ListOptions
+		// the error returned by the callback for the given entry
+		stopAt    string
+		stopWith  error
+		expected  []string
+		expectErr error
+	}{
+		"files recursive": {
+			options:  &ListOptions{Flags: FilesRecursive, Include: []string{"**/*.sp"}},
+			expected: []string{"a.sp", "d1/c.sp", "d1/d2/d.sp"},
+		},
+		"all flat": {
+			options:  &ListOptions{Flags: AllFlat},
+			expected: []string{"a.sp", "b.txt", "d1"},
+		},
+		"directories recursive": {
+			options:  &ListOptions{Flags: DirectoriesRecursive},
+			expected: []string{"d1", "d1/d2"},
+		},
+		"max results": {
+			options:  &ListOptions{Flags: FilesRecursive, MaxResults: 2},
+			expected: []string{"a.sp", "b.txt"},
+		},
+		"max results ignored for flat listing": {
+			options:  &ListOptions{Flags: AllFlat, MaxResults: 2},
+			expected: []string{"a.sp", "b.txt", "d1"},
+		},
+		"skip all": {
+			options:  &ListOptions{Flags: FilesRecursive},
+			stopAt:   "d1/c.sp",
+			stopWith: fs.SkipAll,
+			expected: []string{"a.sp", "b.txt", "d1/c.sp"},
+		},
+		"skip dir recursive": {
+			options:   &ListOptions{Flags: AllRecursive},
+			stopAt:    "d1",
+			stopWith:  fs.SkipDir,
+			expected:  []string{"a.sp", "b.txt", "d1"},
+			expectErr: errSkipDir,
+		},
+		"skip dir flat": {
+			options:   &ListOptions{Flags: AllFlat},
+			stopAt:    "d1",
+			stopWith:  fs.SkipDir,
+			expected:  []string{"a.sp", "b.txt", "d1"},
+			expectErr: errSkipDir,
+		},
+		"skip dir concurrent": {
+			options:   &ListOptions{Flags: DirectoriesRecursive, Concurrency: 4},
+			stopAt:    "d1",
+			stopWith:  fs.SkipDir,
+			expected:  []string{"d1"},
+			expectErr: errSkipDir,
+		},
+		"error": {
+			options:   &ListOptions{Flags: FilesRecursive},
+			stopAt:    "b.txt",
+			stopWith:  errStop,
+			expected:  []string{"a.sp", "b.txt"},
+			expectErr: errStop,
+		},
+	}
+	for name, test := range tests {
+		var paths []string
+		err := Walk(context.Background(), root, test.options, func(entry Entry) error {
+			rel, _ := filepath.Rel(root, entry.Path)
+			rel = filepath.ToSlash(rel)
+			if info, err := os.Stat(entry.Path); err != nil || entry.IsDir() != info.IsDir() {
+				t.Errorf("Test: '%s'' FAILED : unexpected type %v for %s", name, entry.Type, rel)
+			}
+			paths = append(paths, rel)
+			if rel == test.stopAt {
+				return test.stopWith
+			}
+			return nil
+		})
+		if !errors.Is(err, test.expectErr) {
+			t.Errorf("Test: '%s'' FAILED : expected error %v, got %v", name, test.expectErr, err)
+		}
+		if !reflect.DeepEqual(test.expected, paths) {
+			t.Errorf("Test: '%s'' FAILED : expected:\n\n%s\n\ngot:\n\n%s", name, test.expected, paths)
+		}
+	}
+}
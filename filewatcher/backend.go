@@ -0,0 +1,56 @@
+package filewatcher
+
+import (
+	"github.com/fsnotify/fsnotify"
+)
+
+// Backend is the source of file system events for a FileWatcher
+//
+// The default backend uses fsnotify. For file systems where fsnotify does not fire
+// (bind mounted volumes, NFS/SMB shares, some container overlay file systems) a PollingBackend may be used instead
+type Backend interface {
+	// Add starts watching the given file or directory
+	Add(path string) error
+	// Remove stops watching the given file or directory
+	Remove(path string) error
+	// Events returns the channel on which file system events are published
+	Events() <-chan fsnotify.Event
+	// Errors returns the channel on which errors are published
+	Errors() <-chan error
+	// Close stops the backend - the Events and Errors channels are closed
+	Close() error
+}
+
+// fsnotifyBackend is a Backend which wraps an fsnotify.Watcher
+type fsnotifyBackend struct {
+	watcher *fsnotify.Watcher
+}
+
+// NewFsnotifyBackend returns a Backend which uses fsnotify to receive file system events
+func NewFsnotifyBackend() (Backend, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &fsnotifyBackend{watcher: watcher}, nil
+}
+
+func (b *fsnotifyBackend) Add(path string) error {
+	return b.watcher.Add(path)
+}
+
+func (b *fsnotifyBackend) Remove(path string) error {
+	return b.watcher.Remove(path)
+}
+
+func (b *fsnotifyBackend) Events() <-chan fsnotify.Event {
+	return b.watcher.Events
+}
+
+func (b *fsnotifyBackend) Errors() <-chan error {
+	return b.watcher.Errors
+}
+
+func (b *fsnotifyBackend) Close() error {
+	return b.watcher.Close()
+}
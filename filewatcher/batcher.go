@@ -0,0 +1,333 @@
+package filewatcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Debounce controls how events are gathered into batches before the handler is run
+//
+// A debounce window opens with the first event of a batch, and closes once no events have arrived
+// for the Quiet period, or MaxWait has elapsed since the window opened.
+type Debounce struct {
+	// the period with no events after which the batch is handled
+	// if not set, a default of 100ms is used
+	Quiet time.Duration
+	// the maximum time from the first event of a batch until the batch is handled,
+	// even if events are still arriving
+	// if not set, there is no limit
+	MaxWait time.Duration
+	// if set, the first event of a batch is handled immediately when the window opens
+	Leading bool
+	// if set, events are handled when the window closes
+	// if neither Leading or Trailing are set, Trailing is used
+	// if only Leading is set, events which arrive after the leading edge of a window are dropped
+	Trailing bool
+	// the minimum interval between handler runs
+	MinInterval time.Duration
+}
+
+// defaultDebounce runs the handler a short time after the first event of a batch
+func defaultDebounce() Debounce {
+	return Debounce{
+		Quiet:    handlerDelay,
+		MaxWait:  handlerDelay,
+		Trailing: true,
+	}
+}
+
+// batcher gathers events into batches, according to its Debounce settings, and passes each batch to its handler
+// the handler is passed the events of the batch, and the events of a cancelled run which are to be handled again
+type batcher struct {
+	debounce Debounce
+	handler  func(ctx context.Context, events, restarted []fsnotify.Event)
+	clock    Clock
+	// if set, the context of a running handler is cancelled when events are added,
+	// and the events of the cancelled run are handled again with the next batch
+	cancelOnAdd bool
+
+	lock sync.Mutex
+	// events to be handled at the next handler execution
+	events []fsnotify.Event
+	timer  Timer
+	// incremented each time the timer is reset, so a timer which fired before it could be stopped can be ignored
+	timerGeneration int
+	// time of the first and last events of the current debounce window (windowStart is zero if no window is open)
+	windowStart time.Time
+	lastEvent   time.Time
+	// has the leading edge of the current window been handled
+	leadingDone bool
+	// when did the handler last run
+	lastHandlerTime time.Time
+	// is the handler (or an exclusive function) running, and did a batch become due while it was running
+	running bool
+	rerun   bool
+	// signalled when a run completes
+	idle *sync.Cond
+	// cancels the context of the running handler
+	cancelRun context.CancelFunc
+	// the events of cancelled runs, to be handled again with the next batch
+	restarted []fsnotify.Event
+	// set once the batcher is closed - no more events are accepted
+	closed bool
+	// tracks scheduled and running handlers, so close can wait for them
+	waitGroup sync.WaitGroup
+}
+
+func newBatcher(debounce *Debounce, mode HandlerMode, clock Clock, handler func(context.Context, []fsnotify.Event, []fsnotify.Event)) *batcher {
+	d := defaultDebounce()
+	if debounce != nil {
+		d = *debounce
+		if d.Quiet == 0 {
+			d.Quiet = handlerDelay
+		}
+		if !d.Leading && !d.Trailing {
+			d.Trailing = true
+		}
+	}
+	b := &batcher{
+		debounce:    d,
+		handler:     handler,
+		clock:       clock,
+		cancelOnAdd: mode == CancelAndRestart,
+	}
+	b.idle = sync.NewCond(&b.lock)
+	return b
+}
+
+// add adds events to the current batch - the events are kept together in the batch
+func (b *batcher) add(events ...fsnotify.Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed || len(events) == 0 {
+		return
+	}
+
+	now := b.clock.Now()
+	if b.windowStart.IsZero() {
+		// open a new window
+		b.windowStart = now
+		b.leadingDone = false
+	}
+	b.lastEvent = now
+
+	// if we are only handling the leading edge, drop events for the rest of the window
+	if b.leadingDone && !b.debounce.Trailing {
+		// extend the window
+		b.schedule(now)
+		return
+	}
+	b.events = append(b.events, events...)
+	// abandon the running handler - its events are handled again with this batch
+	// (an exclusive function is not cancelled)
+	if b.running && b.cancelOnAdd && b.cancelRun != nil {
+		b.cancelRun()
+	}
+	b.schedule(now)
+}
+
+// schedule sets the timer for the next edge of the current window
+// must be called with the lock held
+func (b *batcher) schedule(now time.Time) {
+	var deadline time.Time
+	if b.debounce.Leading && !b.leadingDone {
+		deadline = now
+	} else {
+		deadline = b.lastEvent.Add(b.debounce.Quiet)
+		if b.debounce.MaxWait > 0 {
+			if maxDeadline := b.windowStart.Add(b.debounce.MaxWait); maxDeadline.Before(deadline) {
+				deadline = maxDeadline
+			}
+		}
+	}
+	// maintain a minimum interval since the last run
+	if len(b.events) > 0 && b.debounce.MinInterval > 0 {
+		if earliest := b.lastHandlerTime.Add(b.debounce.MinInterval); deadline.Before(earliest) {
+			deadline = earliest
+		}
+	}
+	b.resetTimer(deadline.Sub(now))
+}
+
+// must be called with the lock held
+func (b *batcher) resetTimer(delay time.Duration) {
+	b.stopTimer()
+	b.timerGeneration++
+	generation := b.timerGeneration
+	b.waitGroup.Add(1)
+	b.timer = b.clock.AfterFunc(delay, func() { b.fire(generation) })
+}
+
+// must be called with the lock held
+func (b *batcher) stopTimer() {
+	if b.timer != nil && b.timer.Stop() {
+		// the timer function will not run
+		b.waitGroup.Done()
+	}
+	b.timer = nil
+}
+
+func (b *batcher) fire(generation int) {
+	defer b.waitGroup.Done()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// if the timer was reset after this timer fired, there is nothing to do
+	if generation != b.timerGeneration {
+		return
+	}
+	b.timer = nil
+
+	if b.closed {
+		// drain any remaining events
+		b.run()
+		return
+	}
+
+	now := b.clock.Now()
+	leadingEdge := b.debounce.Leading && !b.leadingDone
+	if leadingEdge {
+		b.leadingDone = true
+	} else {
+		// this is the trailing edge - close the window
+		b.windowStart = time.Time{}
+		if !b.debounce.Trailing {
+			// we only handle the leading edge
+			return
+		}
+	}
+
+	// maintain a minimum interval since the last run
+	if len(b.events) > 0 && b.debounce.MinInterval > 0 {
+		if earliest := b.lastHandlerTime.Add(b.debounce.MinInterval); now.Before(earliest) {
+			b.resetTimer(earliest.Sub(now))
+			return
+		}
+	}
+	b.run()
+
+	// after the leading edge, schedule the trailing edge, which closes the window
+	if leadingEdge {
+		b.schedule(b.clock.Now())
+	}
+}
+
+// run passes the pending events to the handler
+// the handler is run without the lock, so events can be added while it runs - these are queued for the next batch
+// runs never overlap - if a batch becomes due while the handler is running, it is handled as soon as the run completes
+// must be called with the lock held
+func (b *batcher) run() {
+	if b.running {
+		b.rerun = true
+		return
+	}
+	for len(b.events) > 0 {
+		events, restarted := b.events, b.restarted
+		// clear events
+		b.events, b.restarted = nil, nil
+		b.running = true
+		b.rerun = false
+		b.lastHandlerTime = b.clock.Now()
+		ctx, cancel := context.WithCancel(context.Background())
+		b.cancelRun = cancel
+
+		b.lock.Unlock()
+		b.handler(ctx, events, restarted)
+		b.lock.Lock()
+
+		b.running = false
+		b.cancelRun = nil
+		b.idle.Broadcast()
+		if ctx.Err() != nil {
+			// the run was cancelled - handle its events again with the next batch
+			b.restarted = append(restarted, events...)
+		}
+		cancel()
+		if !b.rerun {
+			return
+		}
+	}
+}
+
+// runExclusive calls fn once the handler is not running, and does not run the handler until fn returns
+// a batch which becomes due while fn is running is handled as soon as it returns
+func (b *batcher) runExclusive(fn func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for b.running {
+		b.idle.Wait()
+	}
+	b.running = true
+	b.rerun = false
+
+	b.lock.Unlock()
+	fn()
+	b.lock.Lock()
+
+	b.running = false
+	b.idle.Broadcast()
+	if b.rerun {
+		b.run()
+	}
+}
+
+// concatEvents returns the events of a followed by the events of b
+// a new slice is returned if both are non-empty, so neither slice is modified
+func concatEvents(a, b []fsnotify.Event) []fsnotify.Event {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	return append(append(make([]fsnotify.Event, 0, len(a)+len(b)), a...), b...)
+}
+
+// close stops the batcher accepting events
+// pending events are either discarded or handled immediately, according to the policy
+func (b *batcher) close(policy PendingEventPolicy) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	if policy == DiscardPendingEvents {
+		b.events = nil
+		b.restarted = nil
+		b.stopTimer()
+		return
+	}
+	if len(b.events) > 0 {
+		// handle pending events now
+		b.resetTimer(0)
+	}
+}
+
+// discardPending removes the events waiting for a handler run (including those of a cancelled run),
+// closes the current window and returns the events
+func (b *batcher) discardPending() []fsnotify.Event {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	events := append(b.restarted, b.events...)
+	b.events = nil
+	b.restarted = nil
+	b.stopTimer()
+	b.windowStart = time.Time{}
+	return events
+}
+
+// wait returns a channel which is closed when all scheduled and running handlers are complete
+func (b *batcher) wait() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		b.waitGroup.Wait()
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,149 @@
+package filewatcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/go-kit/filewatcher"
+	"github.com/turbot/go-kit/filewatcher/filewatchertest"
+)
+
+// newTestBatcher returns a batcher using a fake clock, which passes each batch to the returned channel
+func newTestBatcher(debounce *filewatcher.Debounce) (filewatcher.Batcher, *filewatchertest.FakeClock, chan []fsnotify.Event) {
+	clock := filewatchertest.NewFakeClock(time.Time{})
+	batches := make(chan []fsnotify.Event, 100)
+	b := filewatcher.NewBatcher(debounce, filewatcher.QueueEvents, clock, func(_ context.Context, events, _ []fsnotify.Event) {
+		batches <- events
+	})
+	return b, clock, batches
+}
+
+// batchSizes returns the sizes of the batches which have been handled since it was last called
+func batchSizes(batches chan []fsnotify.Event) []int {
+	var sizes []int
+	for {
+		select {
+		case b := <-batches:
+			sizes = append(sizes, len(b))
+		default:
+			return sizes
+		}
+	}
+}
+
+func write(name string) fsnotify.Event {
+	return fsnotify.Event{Name: name, Op: fsnotify.Write}
+}
+
+func TestBatcherTrailing(t *testing.T) {
+	b, clock, batches := newTestBatcher(&filewatcher.Debounce{Quiet: 50 * time.Millisecond})
+
+	// events arriving within the quiet period are gathered into one batch
+	for i := 0; i < 5; i++ {
+		b.Add(write("a"))
+		clock.Advance(10 * time.Millisecond)
+	}
+	assert.Empty(t, batchSizes(batches))
+	clock.Advance(40 * time.Millisecond)
+	assert.Equal(t, []int{5}, batchSizes(batches))
+}
+
+func TestBatcherMaxWait(t *testing.T) {
+	b, clock, batches := newTestBatcher(&filewatcher.Debounce{Quiet: 50 * time.Millisecond, MaxWait: 100 * time.Millisecond})
+
+	// events keep arriving within the quiet period - MaxWait forces a batch
+	for i := 0; i < 20; i++ {
+		b.Add(write("a"))
+		clock.Advance(10 * time.Millisecond)
+	}
+	assert.Equal(t, []int{10, 10}, batchSizes(batches))
+}
+
+func TestBatcherLeading(t *testing.T) {
+	b, clock, batches := newTestBatcher(&filewatcher.Debounce{Quiet: 50 * time.Millisecond, Leading: true, Trailing: true})
+
+	// the first event is handled immediately
+	b.Add(write("a"))
+	assert.Len(t, <-batches, 1)
+	// wait for the trailing edge to be scheduled
+	clock.BlockUntil(1)
+
+	b.Add(write("b"))
+	b.Add(write("c"))
+	clock.Advance(49 * time.Millisecond)
+	assert.Empty(t, batchSizes(batches))
+	// later events are handled on the trailing edge
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, []int{2}, batchSizes(batches))
+}
+
+func TestBatcherLeadingOnly(t *testing.T) {
+	b, clock, batches := newTestBatcher(&filewatcher.Debounce{Quiet: 50 * time.Millisecond, Leading: true})
+
+	b.Add(write("a"))
+	assert.Len(t, <-batches, 1)
+	clock.BlockUntil(1)
+
+	// events after the leading edge are dropped
+	b.Add(write("b"))
+	clock.Advance(time.Second)
+	assert.Empty(t, batchSizes(batches))
+
+	// once the window has closed, the next event is handled immediately
+	b.Add(write("c"))
+	assert.Len(t, <-batches, 1)
+}
+
+func TestBatcherMinInterval(t *testing.T) {
+	b, clock, batches := newTestBatcher(&filewatcher.Debounce{Quiet: 10 * time.Millisecond, MinInterval: 200 * time.Millisecond})
+
+	b.Add(write("a"))
+	clock.Advance(50 * time.Millisecond)
+	assert.Equal(t, []int{1}, batchSizes(batches))
+
+	b.Add(write("b"))
+	clock.Advance(50 * time.Millisecond)
+	// the minimum interval has not elapsed
+	assert.Empty(t, batchSizes(batches))
+	clock.Advance(110 * time.Millisecond)
+	assert.Equal(t, []int{1}, batchSizes(batches))
+}
+
+func TestBatcherCancelAndRestart(t *testing.T) {
+	type run struct {
+		events, restarted []fsnotify.Event
+		cancelled         bool
+	}
+	clock := filewatchertest.NewFakeClock(time.Time{})
+	started := make(chan struct{}, 10)
+	runs := make(chan run, 10)
+	b := filewatcher.NewBatcher(&filewatcher.Debounce{Quiet: 10 * time.Millisecond}, filewatcher.CancelAndRestart, clock, func(ctx context.Context, events, restarted []fsnotify.Event) {
+		started <- struct{}{}
+		if len(restarted) == 0 {
+			// the first run does not complete until it is cancelled
+			<-ctx.Done()
+		}
+		runs <- run{events: events, restarted: restarted, cancelled: ctx.Err() != nil}
+	})
+
+	// the handler is called by Advance, so advance in the background while the first run is blocked
+	b.Add(write("a"))
+	advanced := make(chan struct{})
+	go func() {
+		clock.Advance(10 * time.Millisecond)
+		close(advanced)
+	}()
+	<-started
+
+	// a new event cancels the running handler
+	b.Add(write("b"))
+	<-advanced
+	assert.Equal(t, run{events: []fsnotify.Event{write("a")}, cancelled: true}, <-runs)
+
+	// the events of the cancelled run are handled again with the next batch
+	clock.Advance(10 * time.Millisecond)
+	assert.Equal(t, run{events: []fsnotify.Event{write("b")}, restarted: []fsnotify.Event{write("a")}}, <-runs)
+}
@@ -0,0 +1,141 @@
+package filewatcher
+
+import (
+	"sort"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ChangeType is the net effect of a sequence of events on a path
+type ChangeType int
+
+const (
+	// Added means the path did not exist before the events, and does now
+	Added ChangeType = iota + 1
+	// Modified means the path existed before the events, and still does
+	Modified
+	// Deleted means the path existed before the events, and does not now
+	Deleted
+	// Renamed means the path was moved from OldPath
+	Renamed
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case Added:
+		return "added"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	case Renamed:
+		return "renamed"
+	}
+	return "unknown"
+}
+
+// Change is the net change to a single path
+type Change struct {
+	Path string
+	Type ChangeType
+	// for a Renamed change, the path before the rename
+	OldPath string
+	// only set if WatcherOptions.WriteStability is set - true if the size and modified time of the file were unchanged
+	// for the stability period before the change was published, false if the maximum wait elapsed first
+	Stable bool
+}
+
+// ChangeSet is a list of changes, one per path, sorted by path
+type ChangeSet []Change
+
+// pathState tracks the state of a path while building a ChangeSet
+type pathState struct {
+	existedBefore bool
+	exists        bool
+	// the path this path was renamed from (only set if that path existed before the events)
+	renamedFrom string
+}
+
+// NewChangeSet collapses the event sequence for each path into a single net change
+//
+// - a path which is created and then removed (e.g. a temp file) does not appear
+// - a path which is removed and then recreated (e.g. by an editor save) is Modified
+// - a Rename event immediately followed by a Create of another path (as raised by fsnotify
+// for a move within a watched directory) is a rename from the old path to the new path
+//
+// Note: as there is no event for the state before the sequence, a path whose first event is a Create
+// is assumed not to have existed before
+func NewChangeSet(events []fsnotify.Event) ChangeSet {
+	states := make(map[string]*pathState)
+	getState := func(path string, existedBefore bool) *pathState {
+		state, ok := states[path]
+		if !ok {
+			state = &pathState{existedBefore: existedBefore, exists: existedBefore}
+			states[path] = state
+		}
+		return state
+	}
+
+	for i := 0; i < len(events); i++ {
+		ev := events[i]
+		state := getState(ev.Name, !ev.Has(fsnotify.Create))
+		switch {
+		case ev.Has(fsnotify.Remove):
+			state.exists = false
+			state.renamedFrom = ""
+		case ev.Has(fsnotify.Rename):
+			state.exists = false
+			// is this rename paired with a create of the new path
+			if i+1 < len(events) && events[i+1].Has(fsnotify.Create) && events[i+1].Name != ev.Name {
+				i++
+				target := getState(events[i].Name, false)
+				target.exists = true
+				target.renamedFrom = ""
+				// if the old path was itself renamed, track back to the original path
+				if state.renamedFrom != "" {
+					target.renamedFrom = state.renamedFrom
+				} else if state.existedBefore {
+					target.renamedFrom = ev.Name
+				}
+			}
+			state.renamedFrom = ""
+		case ev.Has(fsnotify.Create):
+			state.exists = true
+			state.renamedFrom = ""
+		default:
+			// write or chmod
+			state.exists = true
+		}
+	}
+
+	// build the change set
+	// the sources of renames are not reported separately
+	renameSources := make(map[string]bool)
+	for _, state := range states {
+		if !state.existedBefore && state.exists && state.renamedFrom != "" && !states[state.renamedFrom].exists {
+			renameSources[state.renamedFrom] = true
+		}
+	}
+
+	var res ChangeSet
+	for path, state := range states {
+		switch {
+		case renameSources[path]:
+			continue
+		case !state.existedBefore && state.exists:
+			if state.renamedFrom != "" && renameSources[state.renamedFrom] {
+				res = append(res, Change{Path: path, Type: Renamed, OldPath: state.renamedFrom})
+			} else {
+				res = append(res, Change{Path: path, Type: Added})
+			}
+		case state.existedBefore && state.exists:
+			res = append(res, Change{Path: path, Type: Modified})
+		case state.existedBefore && !state.exists:
+			res = append(res, Change{Path: path, Type: Deleted})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return res
+}
@@ -0,0 +1,84 @@
+package filewatcher
+
+import (
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewChangeSet(t *testing.T) {
+	ev := func(name string, op fsnotify.Op) fsnotify.Event {
+		return fsnotify.Event{Name: name, Op: op}
+	}
+	tests := []struct {
+		name   string
+		events []fsnotify.Event
+		want   ChangeSet
+	}{
+		{
+			name:   "no events",
+			events: nil,
+			want:   nil,
+		},
+		{
+			name:   "create write chmod",
+			events: []fsnotify.Event{ev("/a", fsnotify.Create), ev("/a", fsnotify.Write), ev("/a", fsnotify.Chmod)},
+			want:   ChangeSet{{Path: "/a", Type: Added}},
+		},
+		{
+			name:   "repeated writes",
+			events: []fsnotify.Event{ev("/a", fsnotify.Write), ev("/a", fsnotify.Write)},
+			want:   ChangeSet{{Path: "/a", Type: Modified}},
+		},
+		{
+			name:   "temp file",
+			events: []fsnotify.Event{ev("/a.tmp", fsnotify.Create), ev("/a.tmp", fsnotify.Write), ev("/a.tmp", fsnotify.Remove)},
+			want:   nil,
+		},
+		{
+			name:   "remove and recreate",
+			events: []fsnotify.Event{ev("/a", fsnotify.Remove), ev("/a", fsnotify.Create), ev("/a", fsnotify.Write)},
+			want:   ChangeSet{{Path: "/a", Type: Modified}},
+		},
+		{
+			name:   "delete",
+			events: []fsnotify.Event{ev("/a", fsnotify.Write), ev("/a", fsnotify.Remove)},
+			want:   ChangeSet{{Path: "/a", Type: Deleted}},
+		},
+		{
+			name:   "rename",
+			events: []fsnotify.Event{ev("/a", fsnotify.Rename), ev("/b", fsnotify.Create)},
+			want:   ChangeSet{{Path: "/b", Type: Renamed, OldPath: "/a"}},
+		},
+		{
+			name:   "rename chain",
+			events: []fsnotify.Event{ev("/a", fsnotify.Rename), ev("/b", fsnotify.Create), ev("/b", fsnotify.Rename), ev("/c", fsnotify.Create)},
+			want:   ChangeSet{{Path: "/c", Type: Renamed, OldPath: "/a"}},
+		},
+		{
+			name: "editor backup rename",
+			events: []fsnotify.Event{
+				ev("/a", fsnotify.Rename), ev("/a~", fsnotify.Create),
+				ev("/a", fsnotify.Create), ev("/a", fsnotify.Write),
+				ev("/a~", fsnotify.Remove),
+			},
+			want: ChangeSet{{Path: "/a", Type: Modified}},
+		},
+		{
+			name:   "unpaired rename",
+			events: []fsnotify.Event{ev("/a", fsnotify.Rename), ev("/b", fsnotify.Write)},
+			want:   ChangeSet{{Path: "/a", Type: Deleted}, {Path: "/b", Type: Modified}},
+		},
+		{
+			name:   "sorted by path",
+			events: []fsnotify.Event{ev("/c", fsnotify.Create), ev("/a", fsnotify.Write), ev("/b", fsnotify.Remove)},
+			want:   ChangeSet{{Path: "/a", Type: Modified}, {Path: "/b", Type: Deleted}, {Path: "/c", Type: Added}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, NewChangeSet(tt.events))
+		})
+	}
+}
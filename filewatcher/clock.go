@@ -0,0 +1,36 @@
+package filewatcher
+
+import "time"
+
+// Clock is the source of time for a FileWatcher - debounce windows, rename windows, polling and stats reporting
+// all use the clock, so tests can control time (see the filewatchertest package)
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc waits for the duration to elapse and then calls f
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer created by Clock.AfterFunc
+type Timer interface {
+	// Stop prevents the timer from firing
+	// returns false if the timer has already fired or been stopped
+	Stop() bool
+}
+
+// realClock is a Clock which uses the system time
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,79 @@
+package filewatcher
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/turbot/go-kit/helpers"
+)
+
+const defaultContentHashCacheSize = 10000
+
+// contentHashCache is a bounded, least-recently-used cache of file content hashes
+// it is used to detect writes which do not change the content of a file
+type contentHashCache struct {
+	capacity int
+	// list of *contentHashEntry, most recently used at the front
+	entries *list.List
+	index   map[string]*list.Element
+	lock    sync.Mutex
+}
+
+type contentHashEntry struct {
+	path string
+	hash string
+}
+
+func newContentHashCache(capacity int) *contentHashCache {
+	if capacity <= 0 {
+		capacity = defaultContentHashCacheSize
+	}
+	return &contentHashCache{
+		capacity: capacity,
+		entries:  list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+// update hashes the file at the given path and stores the hash
+// returns whether the content has changed since the previous hash was stored
+// if there is no previous hash, or the file cannot be hashed, the content is considered changed
+func (c *contentHashCache) update(path string) bool {
+	hash, err := helpers.FileHash(path)
+	if err != nil {
+		// we cannot tell - remove any stale hash and assume a change
+		c.evict(path)
+		return true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.index[path]; ok {
+		entry := element.Value.(*contentHashEntry)
+		c.entries.MoveToFront(element)
+		changed := entry.hash != hash
+		entry.hash = hash
+		return changed
+	}
+
+	c.index[path] = c.entries.PushFront(&contentHashEntry{path: path, hash: hash})
+	// if we are over capacity, remove the least recently used entry
+	if c.entries.Len() > c.capacity {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.index, oldest.Value.(*contentHashEntry).path)
+	}
+	return true
+}
+
+// evict removes the hash for the given path
+func (c *contentHashCache) evict(path string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.index[path]; ok {
+		c.entries.Remove(element)
+		delete(c.index, path)
+	}
+}
@@ -0,0 +1,35 @@
+package filewatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentHashCache(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.sp")
+	b := filepath.Join(dir, "b.sp")
+	assert.NoError(t, os.WriteFile(a, []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(b, []byte("b"), 0644))
+
+	cache := newContentHashCache(1)
+
+	// first hash is always a change
+	assert.True(t, cache.update(a))
+	// same content
+	assert.False(t, cache.update(a))
+	// changed content
+	assert.NoError(t, os.WriteFile(a, []byte("a2"), 0644))
+	assert.True(t, cache.update(a))
+
+	// adding b evicts a, as the cache only holds 1 entry
+	assert.True(t, cache.update(b))
+	assert.True(t, cache.update(a))
+
+	// evicted entries are treated as changed
+	cache.evict(a)
+	assert.True(t, cache.update(a))
+}
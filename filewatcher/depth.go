@@ -0,0 +1,75 @@
+package filewatcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/turbot/go-kit/files"
+)
+
+// rootDepth returns how many levels the directory is below the root directory containing it
+// (the roots themselves, and any directory not beneath a root, have a depth of 0)
+func rootDepth(directory string, roots []string) int {
+	depth := -1
+	for _, root := range roots {
+		if directory == root {
+			return 0
+		}
+		if !strings.HasPrefix(directory, root+string(os.PathSeparator)) {
+			continue
+		}
+		rel, err := filepath.Rel(root, directory)
+		if err != nil {
+			continue
+		}
+		// use the closest root
+		if d := len(files.SplitPath(rel)); depth == -1 || d < depth {
+			depth = d
+		}
+	}
+	if depth == -1 {
+		return 0
+	}
+	return depth
+}
+
+// limitListing applies the MaxDepth and FollowSymlinks options to the options for listing beneath a directory which
+// is depth levels below its root directory
+// directories are watched up to MaxDepth levels below their root, so files are in scope one level deeper than that
+// returns false if nothing beneath the directory is in scope
+func (w *FileWatcher) limitListing(opts *files.ListOptions, depth int) bool {
+	opts.FollowSymlinks = w.followSymlinks
+	if w.maxDepth <= 0 {
+		return true
+	}
+	remaining := w.maxDepth - depth
+	if opts.Flags&files.Files != 0 {
+		remaining++
+	}
+	if remaining <= 0 {
+		return false
+	}
+	opts.MaxDepth = remaining
+	return true
+}
+
+// inDepth returns whether a directory should be watched, given the MaxDepth and FollowSymlinks options
+// must be called with the dirLock held
+func (w *FileWatcher) inDepth(directory string) bool {
+	depth := rootDepth(directory, w.rootDirectories)
+	if depth == 0 {
+		// always watch the roots
+		return true
+	}
+	if w.maxDepth > 0 && depth > w.maxDepth {
+		return false
+	}
+	if !w.followSymlinks {
+		// do not watch a symlinked directory (e.g. a symlink created in a watched directory)
+		if info, err := os.Lstat(directory); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package filewatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/go-kit/files"
+)
+
+func TestMaxDepthAndFollowSymlinks(t *testing.T) {
+	// dir
+	// ├── d1/d2/d3
+	// ├── d1/loop -> dir (a cycle, not followed)
+	// └── link -> external
+	dir := t.TempDir()
+	external := t.TempDir()
+	d1 := filepath.Join(dir, "d1")
+	d2 := filepath.Join(d1, "d2")
+	assert.NoError(t, os.MkdirAll(filepath.Join(d2, "d3"), 0755))
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(external, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	assert.NoError(t, os.Symlink(dir, filepath.Join(d1, "loop")))
+
+	changes := make(chan ChangeSet, 10)
+	w, err := NewWatcher(&WatcherOptions{
+		Directories:    []string{dir},
+		Include:        []string{"**/*.sp"},
+		ListFlag:       files.AllRecursive,
+		WatchMode:      WatchDirectories,
+		MaxDepth:       2,
+		FollowSymlinks: true,
+		OnChangeSet:    func(c ChangeSet) { changes <- c },
+	})
+	assert.NoError(t, err)
+	w.Start()
+	defer w.Close(context.Background())
+
+	assert.Equal(t, map[string]bool{dir: true, d1: true, d2: true, link: true}, w.watches)
+
+	// events in the symlink target are reported under the symlink path
+	assert.NoError(t, os.WriteFile(filepath.Join(external, "a.sp"), []byte("a"), 0644))
+	assert.Equal(t, ChangeSet{{Path: filepath.Join(link, "a.sp"), Type: Added}}, receive(t, changes))
+
+	// files in the deepest watched directories are reported
+	b := filepath.Join(d2, "b.sp")
+	assert.NoError(t, os.WriteFile(b, []byte("b"), 0644))
+	assert.Equal(t, ChangeSet{{Path: b, Type: Added}}, receive(t, changes))
+
+	// new directories beyond the max depth are not watched
+	assert.NoError(t, os.Mkdir(filepath.Join(d2, "new"), 0755))
+	c := filepath.Join(d1, "c.sp")
+	assert.NoError(t, os.WriteFile(c, []byte("c"), 0644))
+	assert.Equal(t, ChangeSet{{Path: c, Type: Added}}, receive(t, changes))
+	w.dirLock.Lock()
+	assert.False(t, w.directories[filepath.Join(d2, "new")])
+	w.dirLock.Unlock()
+}
+
+func TestRootDepth(t *testing.T) {
+	roots := []string{filepath.FromSlash("/a"), filepath.FromSlash("/a/b/c"), filepath.FromSlash("/x")}
+	tests := map[string]struct {
+		directory string
+		expected  int
+	}{
+		"root":             {directory: "/a", expected: 0},
+		"child":            {directory: "/a/b", expected: 1},
+		"closest root":     {directory: "/a/b/c/d", expected: 1},
+		"not beneath root": {directory: "/ab/c", expected: 0},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, rootDepth(filepath.FromSlash(test.directory), roots))
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package filewatcher
+
+import (
+	"context"
+	"sort"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// the tests in the filewatcher_test package use the fake clock (filewatchertest imports filewatcher,
+// so it cannot be used by the internal tests) - these expose the internals they test
+
+// Batcher exposes a batcher to the external tests
+type Batcher struct {
+	b *batcher
+}
+
+func NewBatcher(debounce *Debounce, mode HandlerMode, clock Clock, handler func(ctx context.Context, events, restarted []fsnotify.Event)) Batcher {
+	return Batcher{b: newBatcher(debounce, mode, clock, handler)}
+}
+
+func (b Batcher) Add(events ...fsnotify.Event) { b.b.add(events...) }
+func (b Batcher) RunExclusive(fn func())       { b.b.runExclusive(fn) }
+
+// SuppressedPaths returns the paths whose events are suppressed until a time
+func (w *FileWatcher) SuppressedPaths() []string {
+	s := w.selfWrites
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var paths []string
+	for p := range s.until {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
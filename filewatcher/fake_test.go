@@ -0,0 +1,165 @@
+package filewatcher_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/go-kit/files"
+	"github.com/turbot/go-kit/filewatcher"
+	"github.com/turbot/go-kit/filewatcher/filewatchertest"
+)
+
+// these tests use the fake clock and backend, so are in an external test package
+// (filewatchertest imports filewatcher)
+
+// fakeWatcher is a started watcher with a fake clock and backend, which records the batches passed to OnChange
+// and the change sets passed to OnChangeSet
+type fakeWatcher struct {
+	*filewatcher.FileWatcher
+	clock   *filewatchertest.FakeClock
+	backend *filewatchertest.FakeBackend
+	batches [][]fsnotify.Event
+	changes []filewatcher.ChangeSet
+}
+
+func newFakeWatcher(t *testing.T, opts filewatcher.WatcherOptions) *fakeWatcher {
+	w := &fakeWatcher{
+		clock:   filewatchertest.NewFakeClock(time.Time{}),
+		backend: filewatchertest.NewFakeBackend(),
+	}
+	opts.Clock = w.clock
+	opts.Backend = w.backend
+	// the handlers are called by FakeClock.Advance, so do not need to be synchronised
+	opts.OnChange = func(events []fsnotify.Event) { w.batches = append(w.batches, events) }
+	opts.OnChangeSet = func(changes filewatcher.ChangeSet) { w.changes = append(w.changes, changes) }
+	var err error
+	w.FileWatcher, err = filewatcher.NewWatcher(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Start()
+	t.Cleanup(func() { w.Close(context.Background()) })
+	return w
+}
+
+func TestIgnoreUnchangedContent(t *testing.T) {
+	for name, mode := range map[string]filewatcher.WatchMode{"files": filewatcher.WatchFiles, "directories": filewatcher.WatchDirectories} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := filepath.Join(dir, "a.sp")
+			assert.NoError(t, os.WriteFile(a, []byte("a"), 0644))
+
+			w := newFakeWatcher(t, filewatcher.WatcherOptions{
+				Directories:            []string{dir},
+				Include:                []string{"*.sp"},
+				WatchMode:              mode,
+				IgnoreUnchangedContent: true,
+			})
+
+			// the initial content is hashed, so the first write with identical content is not published
+			write := fsnotify.Event{Name: a, Op: fsnotify.Write}
+			w.backend.Send(write)
+			w.clock.Advance(time.Second)
+			assert.Empty(t, w.batches)
+
+			assert.NoError(t, os.WriteFile(a, []byte("changed"), 0644))
+			w.backend.Send(write)
+			w.clock.Advance(time.Second)
+			assert.Equal(t, [][]fsnotify.Event{{write}}, w.batches)
+		})
+	}
+}
+
+func TestFirstPoll(t *testing.T) {
+	dir := t.TempDir()
+	w := newFakeWatcher(t, filewatcher.WatcherOptions{
+		Directories: []string{dir},
+		Include:     []string{"*.sp"},
+	})
+
+	// the first poll is scheduled by Start, so the clock can be advanced past it straight away
+	created := filepath.Join(dir, "created.sp")
+	assert.NoError(t, os.WriteFile(created, []byte("a"), 0644))
+	w.clock.Advance(4 * time.Second)
+	// wait for the poll to set the debounce timer, and schedule the next poll
+	w.clock.BlockUntil(2)
+	w.clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, [][]fsnotify.Event{{{Name: created, Op: fsnotify.Create}}}, w.batches)
+}
+
+func TestNewSubdirectory(t *testing.T) {
+	for name, recursive := range map[string]bool{"recursive": true, "flat": false} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := filewatcher.WatcherOptions{
+				Directories: []string{dir},
+				Include:     []string{"**/*.sp"},
+			}
+			if recursive {
+				opts.ListFlag = files.FilesRecursive
+			}
+			w := newFakeWatcher(t, opts)
+
+			sub := filepath.Join(dir, "sub")
+			assert.NoError(t, os.Mkdir(sub, 0755))
+			w.backend.Send(fsnotify.Event{Name: sub, Op: fsnotify.Create})
+			created := filepath.Join(sub, "created.sp")
+			assert.NoError(t, os.WriteFile(created, []byte("a"), 0644))
+
+			// files in the new directory are found by the next poll, if we are watching recursively
+			w.clock.Advance(4 * time.Second)
+			if !recursive {
+				// wait for the poll to schedule the next poll
+				w.clock.BlockUntil(1)
+				assert.False(t, w.backend.IsWatched(created))
+				return
+			}
+			// wait for the poll to set the debounce timer, and schedule the next poll
+			w.clock.BlockUntil(2)
+			w.clock.Advance(100 * time.Millisecond)
+			assert.Equal(t, [][]fsnotify.Event{{{Name: created, Op: fsnotify.Create}}}, w.batches)
+			assert.True(t, w.backend.IsWatched(created))
+		})
+	}
+}
+
+func TestScopeUpdateReportsNewFiles(t *testing.T) {
+	dir := t.TempDir()
+	w := newFakeWatcher(t, filewatcher.WatcherOptions{
+		Directories: []string{dir},
+		Include:     []string{"*.sp"},
+	})
+
+	// a file created since the last poll is watched by the scope update, so is reported by it rather than the poll
+	created := filepath.Join(dir, "created.sp")
+	assert.NoError(t, os.WriteFile(created, []byte("a"), 0644))
+	w.SetFilters([]string{"*.sp"}, nil)
+	w.clock.Advance(4 * time.Second)
+	assert.Equal(t, [][]fsnotify.Event{{{Name: created, Op: fsnotify.Create}}}, w.batches)
+	assert.True(t, w.backend.IsWatched(created))
+}
+
+func TestCloseDrainsPendingRenames(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.sp")
+	assert.NoError(t, os.WriteFile(a, []byte("a"), 0644))
+
+	w := newFakeWatcher(t, filewatcher.WatcherOptions{
+		Directories:        []string{dir},
+		Include:            []string{"*.sp"},
+		WatchMode:          filewatcher.WatchDirectories,
+		PairRenames:        true,
+		PendingEventPolicy: filewatcher.DrainPendingEvents,
+	})
+
+	// the rename is held waiting for the create event of the new path, but is not lost when the watcher is closed
+	rename := fsnotify.Event{Name: a, Op: fsnotify.Rename}
+	w.backend.Send(rename)
+	assert.NoError(t, w.Close(context.Background()))
+	assert.Equal(t, [][]fsnotify.Event{{rename}}, w.batches)
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package filewatcher
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// getFileID returns the device and inode of the file
+func getFileID(info fs.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{device: uint64(stat.Dev), inode: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package filewatcher
+
+import (
+	"io/fs"
+)
+
+// getFileID is not supported on windows, as the file index is not available from fs.FileInfo
+func getFileID(fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
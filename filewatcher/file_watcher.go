@@ -1,10 +1,15 @@
 package filewatcher
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -16,31 +21,126 @@ import (
 // operations when saving a file
 const handlerDelay = 100 * time.Millisecond
 
+// WatchMode determines what the watcher adds backend watches to
+type WatchMode int
+
+const (
+	// WatchFiles adds a watch to every matching file
+	// new files are discovered by polling the watched directories every 4 seconds
+	WatchFiles WatchMode = iota
+	// WatchDirectories adds a watch to each watched directory only, and filters the events for its children
+	// this uses far fewer watches (file descriptors/inotify watches) than WatchFiles, and new files are reported
+	// as soon as they are created, so no polling is required
+	WatchDirectories
+)
+
+// PendingEventPolicy determines what happens to events waiting for a handler run when a FileWatcher is closed
+type PendingEventPolicy int
+
+const (
+	// DiscardPendingEvents drops any events waiting for a handler run
+	DiscardPendingEvents PendingEventPolicy = iota
+	// DrainPendingEvents passes any events waiting for a handler run to the handler before Close returns
+	DrainPendingEvents
+)
+
 type FileWatcher struct {
-	watch *fsnotify.Watcher
+	watch Backend
+	// creates a replacement backend if the backend stops unexpectedly (nil if only WatcherOptions.Backend was set)
+	newBackend func() (Backend, error)
+	// set if the backend is replaying a journal, in which case the watcher does not raise events itself
+	replay ReplayBackend
 	// directories to watch
 	directories map[string]bool
+	// the directories passed in the options - relative globs are resolved against these
+	rootDirectories []string
 
-	// fnmatch format inclusions/exclusions
+	// fnmatch format inclusions/exclusions, as passed in the options
+	includePatterns []string
+	excludePatterns []string
+	// the inclusions/exclusions resolved against each of the root directories
 	include []string
 	exclude []string
+	// if set, synthetic events are raised for files which enter or leave scope when the directories or filters change
+	emitScopeChanges bool
+	started          atomic.Bool
 
-	listFlag files.ListFlag
+	listFlag  files.ListFlag
+	watchMode WatchMode
+	// the maximum depth of the watched directories below each root (0 for no limit)
+	maxDepth       int
+	followSymlinks bool
 
-	onChange func([]fsnotify.Event)
-	onError  func(error)
+	onChange        func([]fsnotify.Event)
+	onChangeContext func(context.Context, []fsnotify.Event)
+	onChangeSet     func(ChangeSet)
+	onError         func(error)
+	// if set, the context passed to OnChangeContext is cancelled after this duration
+	handlerTimeout time.Duration
+	handlerMode    HandlerMode
+
+	// closed to signal the event loop to stop
+	closeChan chan struct{}
+	// closed when the event loop has stopped
+	loopDone           chan struct{}
+	startOnce          sync.Once
+	closeOnce          sync.Once
+	backendCloseOnce   sync.Once
+	pendingEventPolicy PendingEventPolicy
 
-	closeChan    chan bool
 	pollInterval time.Duration
 	watches      map[string]bool
+	clock        Clock
 
-	dirLock     sync.Mutex
-	handlerLock sync.Mutex
-	// when did the handler last run
-	lastHandlerTime time.Time
-	// events to be handled at the next handler execution
-	events    []fsnotify.Event
+	dirLock sync.Mutex
+	// serialises changes to the root directories and filters
+	scopeLock sync.Mutex
+	// gathers events into batches and runs the handler
+	batcher   *batcher
 	eventMask fsnotify.Op
+	// each route has its own batcher and handlers
+	routes        []*route
+	routeDispatch RouteDispatch
+	// collapses storms of events into a bulk change (nil unless StormDetection is set)
+	storm        *stormDetector
+	onBulkChange func(BulkChange)
+
+	subscribers    map[*subscriber]struct{}
+	subscriberLock sync.Mutex
+	// set once the subscriber channels have been closed by Close
+	subscribersClosed bool
+
+	// pairs renames with the create event of the new path (nil unless PairRenames is set)
+	renames *renameTracker
+
+	// if set, the changes since this snapshot are published when the watcher starts
+	initialSnapshot *Snapshot
+
+	stats         *watcherStats
+	statsReporter func(Stats)
+	statsInterval time.Duration
+
+	// content hashes used to suppress writes which do not change the file content
+	// (nil unless IgnoreUnchangedContent is set)
+	contentHashes *contentHashCache
+
+	// the state of the files in scope, as known from the events received, used to find missed changes when resynchronising
+	known     *Snapshot
+	knownLock sync.Mutex
+	// whether to keep the known state, to publish events for missed changes when resynchronising
+	resyncMissedEvents bool
+
+	// holds events until the file is stable (nil unless WriteStability is set)
+	stability *stabilityTracker
+
+	// records events, filter decisions and batches (nil unless Journal is set)
+	journal *journal
+
+	// the paths which the application is writing, for which events are suppressed
+	selfWrites *selfWrites
+
+	// the rules from the .gitignore and .ignore files in the watched directories (nil unless RespectIgnoreFiles is set)
+	ignore *ignoreRules
 }
 
 type WatcherOptions struct {
@@ -48,111 +148,495 @@ type WatcherOptions struct {
 	Include     []string
 	Exclude     []string
 	OnChange    func([]fsnotify.Event)
-	OnError     func(error)
-	ListFlag    files.ListFlag
+	// OnChangeSet is an alternative to OnChange which is passed the net change to each path, rather than the raw events
+	// OnChange and OnChangeSet may both be set
+	OnChangeSet func(ChangeSet)
+	// OnChangeContext is an alternative to OnChange which is also passed a context, which is cancelled
+	// after HandlerTimeout (if set)
+	//
+	// Handlers (OnChange, OnChangeContext and OnChangeSet) are called one at a time and never overlap.
+	// Events which arrive while a handler is running are queued for the next batch, and do not block event intake.
+	// If a handler panics, the panic is recovered and passed to OnError as a HandlerPanicError.
+	OnChangeContext func(context.Context, []fsnotify.Event)
+	// the maximum time a handler is expected to take - if a handler is still running after this time, the
+	// context passed to OnChangeContext is cancelled, and an error is passed to OnError when the handler returns
+	HandlerTimeout time.Duration
+	// what happens when events arrive while a handler is running (this applies to the handlers of routes too)
+	// if not set, the events are queued for the next batch - use CancelAndRestart to abandon stale work
+	HandlerMode HandlerMode
+	// routes pass the events for paths matching their patterns to their own handlers, with their own debounce
+	// events are passed to the matching routes as well as to OnChange, OnChangeSet and any subscribers
+	Routes []Route
+	// whether an event is passed to the first matching route (the default), or all matching routes
+	RouteDispatch RouteDispatch
+	OnError       func(error)
+	ListFlag      files.ListFlag
 	// a bit mask of the events that you are interested in
 	// e.g: fsnotify.CREATE | fsnotify.REMOVE
 	// if no mask is set, all events are published
 	EventMask fsnotify.Op
+	// the source of file system events
+	// if no backend is set, fsnotify is used
+	// use a PollingBackend for file systems which do not support fsnotify (e.g. NFS/SMB shares)
+	Backend Backend
+	// if set, this is called to create the backend if Backend is not set, and to recreate the backend if it
+	// stops unexpectedly (see ResyncError) - if neither is set, fsnotify is used, and is recreated if it stops
+	NewBackend func() (Backend, error)
+	// if set, the state of the files in scope is captured when the watcher starts and kept up to date from the
+	// events received, so when the watcher resynchronises after events may have been lost (see ResyncError),
+	// events are published for any changes which were missed
+	// Note: this stats every file in scope when the watcher starts, and each published file on every event
+	ResyncMissedEvents bool
+	// if set, Write events are only published if the file content has changed
+	// (editors and formatters often rewrite a file with identical content)
+	IgnoreUnchangedContent bool
+	// the maximum number of file content hashes to cache when IgnoreUnchangedContent is set
+	// if not set, a default of 10000 is used
+	ContentHashCacheSize int
+	// what to do with events waiting for a handler run when the watcher is closed
+	PendingEventPolicy PendingEventPolicy
+	// whether to watch each matching file (the default) or only the directories
+	WatchMode WatchMode
+	// if set, the Rename event for the old path of a file is paired with the Create event for the new path, using
+	// the device and inode of the file. The rename is held for up to RenameWindow and, if paired, is published
+	// immediately before the create, so the ChangeSet reports a single Renamed change with OldPath set
+	//
+	// Note: the pairing is only reported by a ChangeSet (passed to OnChangeSet, or published to subscribers).
+	// OnChange handlers receive the raw Rename and Create events - call NewChangeSet with the events of a batch
+	// to pair them.
+	PairRenames bool
+	// how long to wait for the create event of the new path of a renamed file
+	// if not set, a default of 100ms is used
+	RenameWindow time.Duration
+	// if set, when the watcher starts, the changes between this snapshot and the current state of the watched
+	// directories are published as the first batch of events
+	// (see FileWatcher.Snapshot to capture a snapshot, and Snapshot.Save and LoadSnapshot to persist it)
+	InitialSnapshot *Snapshot
+	// if set, when AddDirectory, RemoveDirectory or SetFilters change which files are in scope,
+	// Create events are raised for files entering scope, and Remove events for files leaving scope
+	EmitScopeChanges bool
+	// if set, this is called with the watcher statistics every StatsInterval while the watcher is running
+	StatsReporter func(Stats)
+	// if not set, a default of 1 minute is used
+	StatsInterval time.Duration
+	// controls how events are gathered into batches before OnChange is called
+	// if not set, OnChange is called 100ms after the first event of a batch
+	Debounce *Debounce
+	// if set, when more than StormDetection.Threshold events arrive within StormDetection.Window, the events are
+	// collapsed into a single BulkChange, which is passed to OnBulkChange once the events stop
+	// (so consumers can do a full reload rather than handle each event)
+	StormDetection *StormDetection
+	// must be set if StormDetection is set
+	OnBulkChange func(BulkChange)
+	// if set, the Create and Write events for a file are held until its size and modified time have been unchanged
+	// for WriteStability.Period (or WriteStability.MaxWait has elapsed) - see Change.Stable
+	WriteStability *WriteStability
+	// if set, every raw event (received from the backend, or raised by the watcher), filter decision and batch is
+	// written to the journal as a JSON line
+	// (see ReadJournal, and filewatchertest.ReplayJournal to reproduce the batching of a recorded journal)
+	Journal io.Writer
+	// the source of time for debouncing, polling and stats reporting
+	// if not set, the system time is used (see the filewatchertest package for a clock which tests can control)
+	Clock Clock
+	// if set, the rules in any .gitignore and .ignore files in the watched directories are applied in addition to
+	// Exclude. As with git, the rules in an ignore file apply to the directory containing it and its descendants,
+	// and the rules are reloaded when an ignore file changes
+	RespectIgnoreFiles bool
+	// if set, recursive watching is limited to directories at most MaxDepth levels below each directory in
+	// Directories (the files in the deepest watched directories are still watched)
+	MaxDepth int
+	// if set, symlinks to directories are followed when watching recursively, and the events for the files
+	// beneath a symlink are reported under the symlink path. Symlinks which would create a cycle are not followed.
+	// Note: if the target of a symlink is also watched through another path, the backend may report its events
+	// under only one of the paths
+	FollowSymlinks bool
 }
 
 func NewWatcher(opts *WatcherOptions) (*FileWatcher, error) {
 	if len(opts.Directories) == 0 {
 		return nil, fmt.Errorf("WatcherOptions must include at least one directory")
 	}
+	if opts.StormDetection != nil && opts.OnBulkChange == nil {
+		return nil, fmt.Errorf("WatcherOptions must include OnBulkChange if StormDetection is set")
+	}
 	if opts.EventMask == 0 {
 		// no mask was sent - we will publish for all events
 		opts.EventMask = fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename | fsnotify.Chmod
 	}
 
-	// Create an fsnotify watcher object
-	watch, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, err
+	watch := opts.Backend
+	newBackend := opts.NewBackend
+	if watch == nil {
+		if newBackend == nil {
+			// Create an fsnotify backend
+			newBackend = NewFsnotifyBackend
+		}
+		var err error
+		watch, err = newBackend()
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	replay, _ := watch.(ReplayBackend)
+
 	// create the watcher
 	watcher := &FileWatcher{
-		watch:           watch,
-		directories:     make(map[string]bool),
-		listFlag:        opts.ListFlag,
-		onChange:        opts.OnChange,
-		onError:         opts.OnError,
-		closeChan:       make(chan bool),
-		pollInterval:    4 * time.Second,
-		watches:         make(map[string]bool),
-		lastHandlerTime: time.Now(),
-		eventMask:       opts.EventMask,
+		watch:              watch,
+		newBackend:         newBackend,
+		replay:             replay,
+		directories:        make(map[string]bool),
+		rootDirectories:    append([]string{}, opts.Directories...),
+		subscribers:        make(map[*subscriber]struct{}),
+		listFlag:           opts.ListFlag,
+		maxDepth:           opts.MaxDepth,
+		followSymlinks:     opts.FollowSymlinks,
+		watchMode:          opts.WatchMode,
+		onChange:           opts.OnChange,
+		onChangeSet:        opts.OnChangeSet,
+		onChangeContext:    opts.OnChangeContext,
+		handlerTimeout:     opts.HandlerTimeout,
+		handlerMode:        opts.HandlerMode,
+		onBulkChange:       opts.OnBulkChange,
+		onError:            opts.OnError,
+		closeChan:          make(chan struct{}),
+		loopDone:           make(chan struct{}),
+		pendingEventPolicy: opts.PendingEventPolicy,
+		pollInterval:       4 * time.Second,
+		watches:            make(map[string]bool),
+		eventMask:          opts.EventMask,
+		routeDispatch:      opts.RouteDispatch,
+		clock:              opts.Clock,
+		initialSnapshot:    opts.InitialSnapshot,
+		includePatterns:    opts.Include,
+		excludePatterns:    opts.Exclude,
+		emitScopeChanges:   opts.EmitScopeChanges,
+		resyncMissedEvents: opts.ResyncMissedEvents,
+		stats:              newWatcherStats(),
+		statsReporter:      opts.StatsReporter,
+		statsInterval:      opts.StatsInterval,
+	}
+	if watcher.clock == nil {
+		watcher.clock = realClock{}
+	}
+	watcher.batcher = newBatcher(opts.Debounce, watcher.handlerMode, watcher.clock, watcher.handleBatch)
+	watcher.selfWrites = newSelfWrites(watcher.clock)
+	if opts.Journal != nil {
+		watcher.journal = newJournal(opts.Journal, watcher.clock)
+	}
+	if opts.WriteStability != nil {
+		watcher.stability = newStabilityTracker(opts.WriteStability, watcher.clock, watcher.releaseStableEvents)
+	}
+	for _, r := range opts.Routes {
+		watcher.routes = append(watcher.routes, newRoute(watcher, r))
+	}
+	if opts.StormDetection != nil {
+		watcher.storm = newStormDetector(opts.StormDetection, watcher.clock, watcher.handleBulkChange)
 	}
+	if watcher.statsInterval <= 0 {
+		watcher.statsInterval = defaultStatsInterval
+	}
+	if opts.PairRenames {
+		watcher.renames = newRenameTracker(opts.RenameWindow, watcher.clock)
+	}
+	if opts.IgnoreUnchangedContent {
+		watcher.contentHashes = newContentHashCache(opts.ContentHashCacheSize)
+	}
+	if opts.RespectIgnoreFiles {
+		watcher.ignore = newIgnoreRules()
+	}
+
+	// convert the inclusions and exclusions into absolute globs byt joing with each of the directories
+	// (this must be done before adding directories, so excluded child directories are not added)
+	watcher.resolveFilters()
 
 	// we store directories as a map to simplify removing and checking for dupes
 	for _, d := range opts.Directories {
 		watcher.addDirectory(d)
 	}
 
-	// convert the inclusions and exclusions into absolute globs byt joing with each of the directories
-	watcher.include = files.ResolveGlobRoots(opts.Include, opts.Directories...)
-	watcher.exclude = files.ResolveGlobRoots(opts.Exclude, opts.Directories...)
-
 	return watcher, nil
 }
 
-func (w *FileWatcher) Close() {
-	if w.watch != nil {
-		w.watch.Close()
+// Close stops the watcher
+//
+// Polling and event intake stop immediately. Events which are waiting for a handler run are either
+// passed to the handler or discarded, according to the PendingEventPolicy.
+// Close then waits for any running handler to complete, or for ctx to be done, in which case ctx.Err() is returned.
+// Once Close returns without error, the handler will not be called again.
+//
+// Close is safe to call more than once, and may be called whether or not the watcher was started.
+// Close must not be called from a handler or OnError, as it waits for them to return - to close the watcher
+// from a callback, call Close in a new goroutine.
+func (w *FileWatcher) Close(ctx context.Context) error {
+	w.closeOnce.Do(func() {
+		// if the watcher was never started, prevent it being started and mark the (non-existent) event loop as done
+		w.startOnce.Do(func() { close(w.loopDone) })
+		// stop the event loop
+		close(w.closeChan)
+
+		// stop accepting events, and discard or drain any pending events
+		if w.stability != nil {
+			w.stability.close(w.pendingEventPolicy)
+		}
+		// (the stability tracker may release renames, so this must be closed after it)
+		if w.renames != nil {
+			w.renames.close(w.pendingEventPolicy, w.scheduleHandler)
+		}
+		for _, b := range w.batchers() {
+			b.close(w.pendingEventPolicy)
+		}
+		if w.storm != nil {
+			w.storm.close(w.pendingEventPolicy)
+		}
+	})
+
+	// wait for the event loop to exit, so the backend is not closed under it
+	if err := w.wait(ctx, w.loopDone); err != nil {
+		return err
+	}
+	var closeErr error
+	w.backendCloseOnce.Do(func() {
+		if w.watch != nil {
+			closeErr = w.watch.Close()
+		}
+	})
+	if closeErr != nil {
+		return closeErr
+	}
+
+	// wait for any scheduled or running handlers
+	var err error
+	for _, b := range w.batchers() {
+		if err = w.wait(ctx, b.wait()); err != nil {
+			break
+		}
+	}
+	if err == nil && w.storm != nil {
+		err = w.wait(ctx, w.storm.wait())
+	}
+	// close subscriber channels - a handler which is still running will not publish to a closed subscriber
+	w.closeSubscribers()
+	return err
+}
+
+// wait waits for the done channel to be closed or the context to be done
+func (w *FileWatcher) wait(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	w.closeChan <- true
 }
 
 func (w *FileWatcher) scheduleCreateEvents(paths []string) {
-	for _, path := range paths {
+	// when replaying a journal, the events raised when it was recorded are replayed instead
+	if w.replay != nil || len(paths) == 0 {
+		return
+	}
+	events := make([]fsnotify.Event, len(paths))
+	for i, path := range paths {
 		// raise a create event for this file
-		w.scheduleHandler(fsnotify.Event{
+		events[i] = fsnotify.Event{
 			Name: path,
 			Op:   fsnotify.Create,
-		})
+		}
 	}
+	w.raiseEvents(JournalSourceListing, events)
 }
 
+// raiseEvents journals and schedules the events which the watcher has raised itself
+func (w *FileWatcher) raiseEvents(source JournalSource, events []fsnotify.Event) {
+	for _, ev := range events {
+		w.journalEvent(ev, source)
+	}
+	if source != JournalSourceListing {
+		// schedule the events together, so they are published in a single batch
+		w.scheduleHandler(events...)
+		return
+	}
+	for _, ev := range events {
+		if w.ignore != nil && isIgnoreFile(ev.Name) {
+			// a new ignore file - pass through the file event handler so the rules are loaded
+			w.handleFileEvent(ev)
+			continue
+		}
+		// files created by the application are found by polling, so must be checked for self writes here
+		if w.suppressSelfWrite(ev) {
+			continue
+		}
+		w.queueStableEvent(ev)
+	}
+}
+
+// Start starts watching - it is equivalent to StartContext(context.Background())
 func (w *FileWatcher) Start() {
+	w.StartContext(context.Background())
+}
+
+// StartContext adds watches on existing files matching our criteria and starts a goroutine to handle file events
+// The watcher stops when ctx is done, or when Close is called
+// Calling StartContext on a started or closed watcher has no effect
+func (w *FileWatcher) StartContext(ctx context.Context) {
+	w.startOnce.Do(func() { w.start(ctx) })
+}
+
+func (w *FileWatcher) start(ctx context.Context) {
+	w.started.Store(true)
+
 	// make an initial call to addWatches to add watches on existing files matching our criteria
 	w.addWatches()
+	if w.resyncMissedEvents {
+		w.captureKnownState()
+	}
+
+	if w.statsReporter != nil {
+		// report until the event loop exits
+		go w.reportStats(w.loopDone)
+	}
+
+	// publish any changes since the initial snapshot
+	if w.initialSnapshot != nil && w.replay == nil {
+		w.scheduleSnapshotChanges(w.initialSnapshot)
+	}
+
+	// when watching directories, new files are reported by the directory watches, so we do not need to poll
+	// (and when replaying a journal, the events raised by polling are replayed)
+	var poll <-chan time.Time
+	schedulePoll := func() {
+		if w.watchMode == WatchFiles && w.replay == nil {
+			poll = w.clock.After(w.pollInterval)
+		}
+	}
+	// schedule the first poll before returning, so a fake clock can be advanced past it as soon as we are started
+	schedulePoll()
 
 	// start a goroutine to poll for file changes, and handle file events
 	go func() {
+		defer close(w.loopDone)
+
+		var raised <-chan RaisedEvents
+		if w.replay != nil {
+			raised = w.replay.RaisedEvents()
+		}
 		for {
 			select {
-			case <-time.After(w.pollInterval):
+			case <-poll:
 				// every poll interval, enumerate files to watch in all watched folders and add watches for any new files
+				pollStart := w.clock.Now()
 				newWatchPaths := w.addWatches()
+				w.stats.polled(w.clock.Now().Sub(pollStart))
+				schedulePoll()
 
 				// fsnotify does not raise CREATE events for new files.
 				// we need raise the CREATE events for all watch paths added
 				w.scheduleCreateEvents(newWatchPaths)
 
-			case ev := <-w.watch.Events:
-				err := w.handleEvent(ev)
-				log.Printf("[TRACE] handleEvent error %v", err)
-				if w.onError != nil {
-					// leave it to the client to decide what to do after an error - it can close us if it wants
-					w.onError(err)
+			case ev, ok := <-w.watch.Events():
+				if !ok {
+					// the backend has stopped - recreate it if we can
+					if w.recreateBackend() {
+						continue
+					}
+					return
+				}
+				w.stats.eventReceived()
+				w.journalEvent(ev, JournalSourceBackend)
+				if err := w.handleEvent(ev); err != nil {
+					log.Printf("[TRACE] handleEvent error %v", err)
+					w.reportError(err)
 				}
 
-			case err := <-w.watch.Errors:
+			case r := <-raised:
+				w.raiseEvents(r.Source, r.Events)
+
+			case err, ok := <-w.watch.Errors():
+				if !ok {
+					// the backend has stopped - recreate it if we can
+					if w.recreateBackend() {
+						continue
+					}
+					return
+				}
 				if err == nil {
 					continue
 				}
+				if errors.Is(err, fsnotify.ErrEventOverflow) {
+					// events have been lost - rescan to find the missed changes
+					w.resync(err, false)
+					continue
+				}
 				log.Printf("[TRACE] file watcher error %v", err)
-				if w.onError != nil {
-					// leave it to the client to decide what to do after an error - it can close us if it wants
-					w.onError(err)
+				w.reportError(err)
+			case <-ctx.Done():
+				// stop intake of events, handling any pending events - Close must still be called to release the backend
+				if w.stability != nil {
+					w.stability.close(DrainPendingEvents)
 				}
+				for _, b := range w.batchers() {
+					b.close(DrainPendingEvents)
+				}
+				if w.storm != nil {
+					w.storm.close(DrainPendingEvents)
+				}
+				return
 			case <-w.closeChan:
 				return
 			}
 		}
 	}()
+}
+
+// Snapshot captures the current state of the files matching our criteria in the watched directories
+// if hash is set, the content hash of each file is included
+func (w *FileWatcher) Snapshot(hash bool) (*Snapshot, error) {
+	listFlag := files.FilesFlat
+	if w.recursive() {
+		listFlag = files.FilesRecursive
+	}
+	include, exclude := w.filters()
+	listOpts := &files.ListOptions{Flags: listFlag}
+	w.limitListing(listOpts, 0)
+	snapshot, err := CaptureSnapshot(w.roots(), &SnapshotOptions{
+		Include:        include,
+		Exclude:        exclude,
+		ListFlag:       listFlag,
+		MaxDepth:       listOpts.MaxDepth,
+		FollowSymlinks: listOpts.FollowSymlinks,
+		Hash:           hash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for p := range snapshot.Entries {
+		if w.isIgnored(p, false) {
+			delete(snapshot.Entries, p)
+		}
+	}
+	return snapshot, nil
+}
+
+// scheduleSnapshotChanges schedules the events for the changes between the given snapshot and the current state
+func (w *FileWatcher) scheduleSnapshotChanges(previous *Snapshot) {
+	// only consider paths which meet our criteria - the snapshot may have been captured with different criteria
+	include, exclude := w.filters()
+	filtered := &Snapshot{Entries: make(map[string]SnapshotEntry)}
+	hash := false
+	for p, entry := range previous.Entries {
+		if files.ShouldIncludePath(p, include, exclude) && !w.isIgnored(p, false) {
+			filtered.Entries[p] = entry
+			hash = hash || entry.Hash != ""
+		}
+	}
 
+	current, err := w.Snapshot(hash)
+	if err != nil {
+		log.Printf("[TRACE] failed to capture snapshot: %v", err)
+		w.reportError(err)
+		return
+	}
+	w.raiseEvents(JournalSourceSnapshot, filtered.events(current))
 }
 
 // addWatches recurses through the directory trees and adds watches to all
@@ -161,6 +645,12 @@ func (w *FileWatcher) Start() {
 func (w *FileWatcher) addWatches() []string {
 	w.dirLock.Lock()
 	defer w.dirLock.Unlock()
+
+	if w.watchMode == WatchDirectories {
+		w.addDirectoryWatches()
+		return nil
+	}
+
 	// enumerate all files meeting inclusions and exclusions in each watched directory and add a watch
 	opts := &files.ListOptions{
 		Flags:   files.FilesFlat,
@@ -170,11 +660,14 @@ func (w *FileWatcher) addWatches() []string {
 	var errors []error
 	var newWatchPaths []string
 	for directory := range w.directories {
-		sourcePaths, err := files.ListFiles(directory, opts)
+		sourcePaths, err := w.listFiles(directory, opts)
 		if err != nil {
 			errors = append(errors, err)
 			continue
 		}
+		// watch the ignore files in the directory, so the rules can be reloaded when they change
+		// (any new ignore files are returned with the new watch paths, so the rules are loaded)
+		sourcePaths = append(sourcePaths, w.ignoreFiles(directory)...)
 		// add watches for all files we find (if we are not already watching)
 		for _, p := range sourcePaths {
 			if !w.watches[p] {
@@ -182,6 +675,13 @@ func (w *FileWatcher) addWatches() []string {
 					errors = append(errors, err)
 				} else {
 					newWatchPaths = append(newWatchPaths, p)
+					if w.renames != nil {
+						w.renames.record(p)
+					}
+					// store the initial content hash so the first unchanged write can be detected
+					if w.contentHashes != nil {
+						w.contentHashes.update(p)
+					}
 				}
 			}
 		}
@@ -197,6 +697,47 @@ func (w *FileWatcher) addWatches() []string {
 	return newWatchPaths
 }
 
+// addDirectoryWatches adds watches to all directories which are not being watched yet
+// must be called with the dirLock held
+func (w *FileWatcher) addDirectoryWatches() {
+	for directory := range w.directories {
+		if w.watches[directory] {
+			continue
+		}
+		if err := w.addWatch(directory); err != nil {
+			log.Printf("[TRACE] error occurred setting watches: %v", err)
+			continue
+		}
+		// store the file ids and content hashes of the existing files
+		if w.renames != nil || w.contentHashes != nil {
+			w.recordExistingFiles(directory)
+		}
+	}
+}
+
+// recordExistingFiles stores the file ids (so renames can be paired) and the initial content hashes
+// (so the first unchanged write can be detected) of the files in the directory which match our criteria
+func (w *FileWatcher) recordExistingFiles(directory string) {
+	opts := &files.ListOptions{
+		Flags:   files.FilesFlat,
+		Exclude: w.exclude,
+		Include: w.include,
+	}
+	paths, err := w.listFiles(directory, opts)
+	if err != nil {
+		log.Printf("[TRACE] failed to list files in '%s': %v", directory, err)
+		return
+	}
+	for _, p := range paths {
+		if w.renames != nil {
+			w.renames.record(p)
+		}
+		if w.contentHashes != nil {
+			w.contentHashes.update(p)
+		}
+	}
+}
+
 func (w *FileWatcher) addWatch(path string) error {
 	// add the watch
 	if err := w.watch.Add(path); err != nil {
@@ -225,38 +766,87 @@ func (w *FileWatcher) handleEvent(ev fsnotify.Event) error {
 func (w *FileWatcher) handleFileEvent(ev fsnotify.Event) {
 	log.Printf("[TRACE] file watcher event %v", ev)
 
+	// if an ignore file has changed, reload its rules (the ignore file itself may also be included)
+	if w.ignore != nil && isIgnoreFile(ev.Name) {
+		w.reloadIgnoreFile(ev)
+	}
+
 	// check whether file name meets file inclusion/exclusions
-	if files.ShouldIncludePath(ev.Name, w.include, w.exclude) {
-		log.Printf("[TRACE] notify file change")
+	include, exclude := w.filters()
+	included := files.ShouldIncludePath(ev.Name, include, exclude)
+	if included && w.isIgnored(ev.Name, false) {
+		included = false
+		w.journalFilter(ev, "ignored by ignore file")
+	} else if !included {
+		w.journalFilter(ev, "excluded")
+	}
+	if included {
+		if !w.contentChanged(ev) {
+			log.Printf("[TRACE] ignore write with unchanged content %v", ev)
+			w.journalFilter(ev, "unchanged content")
+			return
+		}
+		if !w.suppressSelfWrite(ev) {
+			log.Printf("[TRACE] notify file change")
+			w.journalFilter(ev, "")
 
-		// schedule a handler run - maintain a minimum interval since the last run
-		w.scheduleHandler(ev)
+			// schedule a handler run
+			w.queueStableEvent(ev)
+		}
 		// if this was a deletion or rename event, remove our local watch flag
 		if ev.Op == fsnotify.Remove || ev.Op == fsnotify.Rename {
+			w.dirLock.Lock()
 			w.watches[ev.Name] = false
+			w.dirLock.Unlock()
 		}
 	} else {
 		log.Printf("[TRACE] ignore file change %v", ev)
+		w.stats.eventFiltered()
+	}
+}
+
+// contentChanged updates the content hash cache for the event and returns whether the event should be published
+// only Write events which do not change the file content are suppressed
+func (w *FileWatcher) contentChanged(ev fsnotify.Event) bool {
+	if w.contentHashes == nil {
+		return true
+	}
+	switch {
+	case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+		w.contentHashes.evict(ev.Name)
+	case ev.Has(fsnotify.Create):
+		w.contentHashes.update(ev.Name)
+	case ev.Has(fsnotify.Write):
+		return w.contentHashes.update(ev.Name)
 	}
+	return true
 }
 
 // if we are watching recursively, add or remove the folder from our list of watched folders
 func (w *FileWatcher) handleFolderEvent(ev fsnotify.Event) error {
 	// if we are not watching recursively, we do not care about folder events
-	if w.recursive() {
+	if !w.recursive() {
 		return nil
 	}
 
-	// check whether dirname meets directory inclusions/exclusions
-	if files.ShouldIncludePath(ev.Name, w.include, w.exclude) {
+	// check whether dirname meets directory exclusions
+	// (inclusions are file patterns, so are not applied to directories)
+	_, exclude := w.filters()
+	if files.ShouldIncludePath(ev.Name, nil, exclude) {
 		// if it a create event, add to our list of watched folders
 		if ev.Op == fsnotify.Create {
 			log.Printf("[TRACE] new directory created: '%s' - add watch", ev.Name)
 			w.addDirectory(ev.Name)
-			// we will just wait until nex scheduled poll to add files in this directory
+			if w.watchMode == WatchDirectories {
+				// add watches for the new directories now, and raise create events for any files
+				// which were created before the watches were added
+				w.addWatches()
+				w.scheduleCreateEvents(w.listNewDirectoryFiles(ev.Name))
+			}
+			// otherwise we will just wait until next scheduled poll to add files in this directory
 		}
-		// it is a deletion, remove watch
-		if ev.Op == fsnotify.Remove {
+		// it is a deletion (or the directory has been moved away), remove watch
+		if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
 			log.Printf("[TRACE] new directory deleted: '%s' - remove watch", ev.Name)
 			w.removeDirectory(ev.Name)
 		}
@@ -266,10 +856,40 @@ func (w *FileWatcher) handleFolderEvent(ev fsnotify.Event) error {
 	return nil
 }
 
+// listNewDirectoryFiles returns the files matching our criteria in a newly created directory
+func (w *FileWatcher) listNewDirectoryFiles(name string) []string {
+	include, exclude := w.filters()
+	opts := &files.ListOptions{
+		Flags:   files.FilesRecursive,
+		Include: include,
+		Exclude: exclude,
+	}
+	if !w.limitListing(opts, rootDepth(name, w.roots())) {
+		return nil
+	}
+	paths, err := w.listFiles(name, opts)
+	if err != nil {
+		log.Printf("[TRACE] failed to list files in new directory '%s': %v", name, err)
+	}
+	return paths
+}
+
+// reportError records the error in our stats and passes it to the error handler
+func (w *FileWatcher) reportError(err error) {
+	w.stats.errorOccurred(err, w.clock.Now())
+	if w.onError != nil {
+		// leave it to the client to decide what to do after an error
+		w.onError(err)
+	}
+}
+
 func (w *FileWatcher) isFolder(ev fsnotify.Event) bool {
 	info, err := os.Stat(ev.Name)
 	if err != nil {
-		return false
+		// the path may have been deleted - check whether it was one of our directories
+		w.dirLock.Lock()
+		defer w.dirLock.Unlock()
+		return w.directories[ev.Name]
 	}
 	return info.IsDir()
 }
@@ -278,6 +898,18 @@ func (w *FileWatcher) addDirectory(name string) {
 	w.dirLock.Lock()
 	defer w.dirLock.Unlock()
 
+	for _, d := range w.listDirectories(name) {
+		w.directories[d] = true
+	}
+}
+
+// listDirectories returns the directory and, if we are watching recursively, its child directories which should be watched
+// must be called with the dirLock held
+func (w *FileWatcher) listDirectories(name string) []string {
+	// skip directories beyond the max depth, and symlinks if we are not following them
+	if !w.inDepth(name) {
+		return nil
+	}
 	directories := []string{name}
 
 	// if we are watching recursively, add child directories
@@ -286,60 +918,109 @@ func (w *FileWatcher) addDirectory(name string) {
 			Flags:   files.DirectoriesRecursive,
 			Exclude: w.exclude,
 		}
-		childDirectories, err := files.ListFiles(name, opts)
-		if err != nil {
-			// ShowWarning(fmt.Sprintf("failed to add recursive watch on directory '%s': %v", name, err))
-			log.Printf("[WARN] %s", fmt.Sprintf("failed to add recursive watch on directory '%s': %v", name, err))
+		if w.limitListing(opts, rootDepth(name, w.rootDirectories)) {
+			childDirectories, err := files.ListFiles(name, opts)
+			if err != nil {
+				// ShowWarning(fmt.Sprintf("failed to add recursive watch on directory '%s': %v", name, err))
+				log.Printf("[WARN] %s", fmt.Sprintf("failed to add recursive watch on directory '%s': %v", name, err))
+			}
+			directories = append(directories, childDirectories...)
 		}
-		directories = append(directories, childDirectories...)
 	}
-
-	for _, d := range directories {
-		w.directories[d] = true
+	if w.ignore != nil {
+		// load the ignore files in each directory, and skip any ignored directories
+		directories = w.ignore.loadDirectories(directories)
 	}
+	return directories
 }
 
 func (w *FileWatcher) removeDirectory(name string) {
 	w.dirLock.Lock()
 	defer w.dirLock.Unlock()
 
-	delete(w.directories, name)
-
+	// remove the directory and any child directories
+	prefix := name + string(os.PathSeparator)
+	for d := range w.directories {
+		if d != name && !strings.HasPrefix(d, prefix) {
+			continue
+		}
+		delete(w.directories, d)
+		if w.watchMode == WatchDirectories && w.watches[d] {
+			// the backend may already have removed the watch for a deleted directory, so ignore errors
+			_ = w.watch.Remove(d)
+			delete(w.watches, d)
+		}
+	}
 }
 
 func (w *FileWatcher) recursive() bool {
 	return w.listFlag&files.Recursive != 0
 }
 
-func (w *FileWatcher) scheduleHandler(ev fsnotify.Event) {
-	w.handlerLock.Lock()
-	defer w.handlerLock.Unlock()
-
-	if ev.Op&w.eventMask == 0 {
-		// this is not an event that we are interested in
+// queueEvent passes an event which has passed our filters to the handler scheduler
+// if rename pairing is enabled, a rename is held until the create event for the new path arrives
+func (w *FileWatcher) queueEvent(ev fsnotify.Event) {
+	if w.renames == nil {
+		w.scheduleHandler(ev)
 		return
 	}
 
-	// we can tell if a handler is scheduled by looking at the events array
-	handlerScheduled := len(w.events) > 0
-	// now add our event to the array
-	w.events = append(w.events, ev)
+	switch {
+	case ev.Has(fsnotify.Rename):
+		if w.renames.rename(ev, w.scheduleHandler) {
+			if w.watchMode == WatchFiles {
+				// the new path would not be discovered until the next poll - look for it now
+				w.scheduleCreateEvents(w.addWatches())
+			}
+			return
+		}
+	case ev.Has(fsnotify.Create):
+		if renameEvent, ok := w.renames.create(ev.Name); ok {
+			// schedule the rename and the create together, so they are adjacent in the batch
+			w.scheduleHandler(renameEvent, ev)
+			return
+		}
+	case ev.Has(fsnotify.Remove):
+		w.renames.remove(ev.Name)
+	default:
+		w.renames.record(ev.Name)
+	}
+	w.scheduleHandler(ev)
+}
 
-	// if handler scheduled, there is nothing to do, it will process this event
-	if handlerScheduled {
+func (w *FileWatcher) scheduleHandler(events ...fsnotify.Event) {
+	var interesting []fsnotify.Event
+	for _, ev := range events {
+		if ev.Op&w.eventMask == 0 {
+			// this is not an event that we are interested in
+			w.stats.eventMasked()
+			continue
+		}
+		interesting = append(interesting, ev)
+	}
+	w.updateKnownState(interesting)
+	if w.handleStorm(interesting) {
 		return
 	}
+	w.batcher.add(interesting...)
+	w.dispatchToRoutes(interesting)
+}
 
-	// so no handler is scheduled - schedule handler to run - with a short pause
-	go func() {
-		time.Sleep(handlerDelay)
-		// lock the handlerLock AFTER the delay
-		w.handlerLock.Lock()
-		defer w.handlerLock.Unlock()
-
-		w.lastHandlerTime = time.Now()
-		w.onChange(w.events)
-		// clear events - this indicates no handler is scheduled
-		w.events = nil
+// handleBatch is called by the batcher with each batch of events
+func (w *FileWatcher) handleBatch(ctx context.Context, events, restarted []fsnotify.Event) {
+	start := w.clock.Now()
+	defer func() {
+		w.stats.batchDelivered(len(events), w.clock.Now().Sub(start))
 	}()
+
+	// the handlers are passed the events of a cancelled batch again, but the journal and subscribers have already
+	// received them
+	w.journalBatch(clearStable(events))
+	handlerEvents := concatEvents(restarted, events)
+	w.callHandlers(ctx, handlers{
+		onChange:        w.onChange,
+		onChangeContext: w.onChangeContext,
+		onChangeSet:     w.onChangeSet,
+	}, handlerEvents)
+	w.publishToSubscribers(events)
 }
@@ -0,0 +1,171 @@
+package filewatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/go-kit/files"
+)
+
+func TestCloseWithoutStart(t *testing.T) {
+	w, err := NewWatcher(&WatcherOptions{
+		Directories: []string{t.TempDir()},
+		OnChange:    func([]fsnotify.Event) {},
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, w.Close(ctx))
+	// a second close is a no-op
+	assert.NoError(t, w.Close(ctx))
+	// starting a closed watcher has no effect
+	w.Start()
+	assert.NoError(t, w.Close(ctx))
+}
+
+func TestCloseDrainsPendingEvents(t *testing.T) {
+	dir := t.TempDir()
+
+	var lock sync.Mutex
+	var received []fsnotify.Event
+	w, err := NewWatcher(&WatcherOptions{
+		Directories: []string{dir},
+		OnChange: func(events []fsnotify.Event) {
+			lock.Lock()
+			defer lock.Unlock()
+			received = append(received, events...)
+		},
+		PendingEventPolicy: DrainPendingEvents,
+	})
+	assert.NoError(t, err)
+	w.Start()
+
+	path := filepath.Join(dir, "a.sp")
+	assert.NoError(t, os.WriteFile(path, []byte("a"), 0644))
+	w.handleFileEvent(fsnotify.Event{Name: path, Op: fsnotify.Create})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, w.Close(ctx))
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []fsnotify.Event{{Name: path, Op: fsnotify.Create}}, received)
+}
+
+func TestCloseDiscardsPendingEvents(t *testing.T) {
+	dir := t.TempDir()
+
+	called := false
+	w, err := NewWatcher(&WatcherOptions{
+		Directories: []string{dir},
+		OnChange:    func([]fsnotify.Event) { called = true },
+	})
+	assert.NoError(t, err)
+	w.Start()
+
+	w.handleFileEvent(fsnotify.Event{Name: filepath.Join(dir, "a.sp"), Op: fsnotify.Create})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, w.Close(ctx))
+	assert.False(t, called)
+}
+
+func TestWatchDirectories(t *testing.T) {
+	dir := t.TempDir()
+
+	changes := make(chan ChangeSet, 10)
+	w, err := NewWatcher(&WatcherOptions{
+		Directories: []string{dir},
+		Include:     []string{"**/*.sp"},
+		ListFlag:    files.AllRecursive,
+		WatchMode:   WatchDirectories,
+		OnChangeSet: func(c ChangeSet) { changes <- c },
+	})
+	assert.NoError(t, err)
+	w.Start()
+	defer w.Close(context.Background())
+
+	// only the directory is watched
+	assert.Equal(t, map[string]bool{dir: true}, w.watches)
+
+	// a new file is reported without waiting for a poll
+	a := filepath.Join(dir, "a.sp")
+	assert.NoError(t, os.WriteFile(a, []byte("a"), 0644))
+	// files which do not match the include are ignored
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+	assert.Equal(t, ChangeSet{{Path: a, Type: Added}}, receive(t, changes))
+
+	// files in new sub directories are reported
+	sub := filepath.Join(dir, "sub")
+	assert.NoError(t, os.Mkdir(sub, 0755))
+	b := filepath.Join(sub, "b.sp")
+	assert.NoError(t, os.WriteFile(b, []byte("b"), 0644))
+	assert.Contains(t, receive(t, changes), Change{Path: b, Type: Added})
+}
+
+func TestPairRenames(t *testing.T) {
+	for name, mode := range map[string]WatchMode{"files": WatchFiles, "directories": WatchDirectories} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := filepath.Join(dir, "a.sp")
+			assert.NoError(t, os.WriteFile(a, []byte("a"), 0644))
+
+			changes := make(chan ChangeSet, 10)
+			w, err := NewWatcher(&WatcherOptions{
+				Directories: []string{dir},
+				Include:     []string{"**/*.sp"},
+				WatchMode:   mode,
+				PairRenames: true,
+				OnChangeSet: func(c ChangeSet) { changes <- c },
+			})
+			assert.NoError(t, err)
+			w.Start()
+			defer w.Close(context.Background())
+
+			b := filepath.Join(dir, "b.sp")
+			assert.NoError(t, os.Rename(a, b))
+			assert.Equal(t, ChangeSet{{Path: b, Type: Renamed, OldPath: a}}, receive(t, changes))
+		})
+	}
+}
+
+func TestInitialSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.sp")
+	b := filepath.Join(dir, "b.sp")
+	assert.NoError(t, os.WriteFile(a, []byte("a"), 0644))
+
+	opts := &WatcherOptions{
+		Directories: []string{dir},
+		Include:     []string{"**/*.sp"},
+		ListFlag:    files.FilesRecursive,
+	}
+	w, err := NewWatcher(opts)
+	assert.NoError(t, err)
+	snapshot, err := w.Snapshot(true)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close(context.Background()))
+
+	// make changes while we are not watching
+	assert.NoError(t, os.Remove(a))
+	assert.NoError(t, os.WriteFile(b, []byte("b"), 0644))
+
+	changes := make(chan ChangeSet, 10)
+	opts.InitialSnapshot = snapshot
+	opts.OnChangeSet = func(c ChangeSet) { changes <- c }
+	w, err = NewWatcher(opts)
+	assert.NoError(t, err)
+	w.Start()
+	defer w.Close(context.Background())
+
+	assert.Equal(t, ChangeSet{{Path: a, Type: Deleted}, {Path: b, Type: Added}}, receive(t, changes))
+}
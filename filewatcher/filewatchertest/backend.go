@@ -0,0 +1,133 @@
+package filewatchertest
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// FakeBackend is a filewatcher.Backend whose events are injected by the test
+type FakeBackend struct {
+	events chan fsnotify.Event
+	errors chan error
+	// closed when the backend is closed, to unblock any pending send
+	closed chan struct{}
+	// held while sending, so the channels are not closed during a send
+	sendLock  sync.Mutex
+	closeOnce sync.Once
+
+	lock    sync.Mutex
+	watches map[string]bool
+}
+
+// NewFakeBackend returns a FakeBackend with no watches
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		events:  make(chan fsnotify.Event),
+		errors:  make(chan error),
+		closed:  make(chan struct{}),
+		watches: make(map[string]bool),
+	}
+}
+
+// Add records a watch on the path
+func (b *FakeBackend) Add(path string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.watches[path] = true
+	return nil
+}
+
+// Remove removes the watch on the path
+func (b *FakeBackend) Remove(path string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.watches, path)
+	return nil
+}
+
+func (b *FakeBackend) Events() <-chan fsnotify.Event {
+	return b.events
+}
+
+func (b *FakeBackend) Errors() <-chan error {
+	return b.errors
+}
+
+// Close closes the Events and Errors channels
+func (b *FakeBackend) Close() error {
+	b.closeOnce.Do(func() {
+		// unblock any pending send before waiting for the send lock
+		close(b.closed)
+		b.sendLock.Lock()
+		defer b.sendLock.Unlock()
+		close(b.events)
+		close(b.errors)
+	})
+	return nil
+}
+
+// Watches returns the watched paths, sorted
+func (b *FakeBackend) Watches() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	res := make([]string, 0, len(b.watches))
+	for p := range b.watches {
+		res = append(res, p)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// IsWatched returns whether the path is watched
+func (b *FakeBackend) IsWatched(path string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.watches[path]
+}
+
+// Send passes the events to the watcher, and returns once the watcher has handled them
+// (i.e. the events have been filtered and added to the current batch)
+// the watcher must have been started - Send returns without sending if the backend is closed
+func (b *FakeBackend) Send(events ...fsnotify.Event) {
+	b.sendLock.Lock()
+	defer b.sendLock.Unlock()
+	for _, ev := range events {
+		select {
+		case b.events <- ev:
+		case <-b.closed:
+			return
+		}
+	}
+	b.sync()
+}
+
+// SendError passes the error to the watcher, and returns once the watcher has handled it
+func (b *FakeBackend) SendError(err error) {
+	b.sendLock.Lock()
+	defer b.sendLock.Unlock()
+	select {
+	case b.errors <- err:
+	case <-b.closed:
+		return
+	}
+	b.sync()
+}
+
+// Sync returns once the watcher has finished handling the previous event or error
+func (b *FakeBackend) Sync() {
+	b.sendLock.Lock()
+	defer b.sendLock.Unlock()
+	b.sync()
+}
+
+// sync sends a nil error, which the watcher ignores - as the watcher handles events and errors one at a time,
+// once the nil error has been received, any earlier event or error has been handled
+// must be called with the sendLock held
+func (b *FakeBackend) sync() {
+	select {
+	case b.errors <- nil:
+	case <-b.closed:
+	}
+}
@@ -0,0 +1,130 @@
+// Package filewatchertest provides a fake clock and a fake backend for testing code which uses a filewatcher.FileWatcher
+//
+// Pass a FakeClock as WatcherOptions.Clock and a FakeBackend as WatcherOptions.Backend. Tests can then inject
+// events with FakeBackend.Send and advance time past debounce and poll boundaries with FakeClock.Advance,
+// without sleeping.
+package filewatchertest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/turbot/go-kit/filewatcher"
+)
+
+// FakeClock is a filewatcher.Clock whose time only moves when Advance is called
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+	// the timers which have not yet fired or been stopped
+	timers []*fakeTimer
+	// signalled when a timer is added
+	timerAdded *sync.Cond
+}
+
+// NewFakeClock returns a FakeClock set to the given time
+// if the time is zero, an arbitrary fixed time is used
+func NewFakeClock(now time.Time) *FakeClock {
+	if now.IsZero() {
+		now = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	c := &FakeClock{now: now}
+	c.timerAdded = sync.NewCond(&c.lock)
+	return c
+}
+
+// Now returns the current time of the clock
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// After returns a channel on which the time is sent when the clock has been advanced by the duration
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+	return ch
+}
+
+// AfterFunc calls f when the clock has been advanced by the duration
+// f is called by Advance, so has completed when Advance returns
+// as with time.AfterFunc, if the duration is not positive f is called immediately in its own goroutine
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) filewatcher.Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &fakeTimer{clock: c, deadline: c.now.Add(d), f: f}
+	if d <= 0 {
+		go f()
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.timerAdded.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward by the duration, firing any timers which are due in deadline order
+// timers which are set while advancing (e.g. by a timer function) also fire if they are due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	target := c.now.Add(d)
+	c.lock.Unlock()
+
+	for {
+		c.lock.Lock()
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].deadline.Before(c.timers[j].deadline) })
+		if len(c.timers) == 0 || c.timers[0].deadline.After(target) {
+			c.now = target
+			c.lock.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.deadline.After(c.now) {
+			c.now = t.deadline
+		}
+		c.lock.Unlock()
+
+		// call the timer function without the lock, so it can use the clock
+		t.f()
+	}
+}
+
+// PendingTimers returns the number of timers which have not yet fired or been stopped
+func (c *FakeClock) PendingTimers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil waits until there are at least n pending timers
+// this allows a test to wait for a goroutine (e.g. the watcher event loop) to set a timer before advancing the clock
+func (c *FakeClock) BlockUntil(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for len(c.timers) < n {
+		c.timerAdded.Wait()
+	}
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	f        func()
+}
+
+// Stop removes the timer from the clock - returns false if the timer has already fired or been stopped
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package filewatchertest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/go-kit/filewatcher"
+)
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	start := clock.Now()
+
+	var fired []string
+	clock.AfterFunc(20*time.Millisecond, func() {
+		fired = append(fired, "b")
+		// timers set by a timer function fire in the same Advance if they are due
+		clock.AfterFunc(5*time.Millisecond, func() { fired = append(fired, "c") })
+	})
+	clock.AfterFunc(10*time.Millisecond, func() { fired = append(fired, "a") })
+	stopped := clock.AfterFunc(15*time.Millisecond, func() { fired = append(fired, "stopped") })
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+	after := clock.After(time.Second)
+
+	clock.Advance(9 * time.Millisecond)
+	assert.Empty(t, fired)
+	clock.Advance(21 * time.Millisecond)
+	assert.Equal(t, []string{"a", "b", "c"}, fired)
+	assert.Equal(t, start.Add(30*time.Millisecond), clock.Now())
+	assert.Equal(t, 1, clock.PendingTimers())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, start.Add(time.Second), <-after)
+	assert.Equal(t, 0, clock.PendingTimers())
+}
+
+func TestWatcherWithFakes(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.sp")
+	assert.NoError(t, os.WriteFile(existing, []byte("a"), 0644))
+
+	clock := NewFakeClock(time.Time{})
+	backend := NewFakeBackend()
+	var batches [][]fsnotify.Event
+	w, err := filewatcher.NewWatcher(&filewatcher.WatcherOptions{
+		Directories: []string{dir},
+		Include:     []string{"*.sp"},
+		Clock:       clock,
+		Backend:     backend,
+		OnChange:    func(events []fsnotify.Event) { batches = append(batches, events) },
+	})
+	assert.NoError(t, err)
+	w.Start()
+	defer w.Close(context.Background())
+	assert.Equal(t, []string{existing}, backend.Watches())
+
+	// events are batched until the debounce window closes
+	write := fsnotify.Event{Name: existing, Op: fsnotify.Write}
+	backend.Send(write)
+	clock.Advance(99 * time.Millisecond)
+	assert.Empty(t, batches)
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, [][]fsnotify.Event{{write}}, batches)
+
+	// new files are found when the poll interval elapses
+	created := filepath.Join(dir, "created.sp")
+	assert.NoError(t, os.WriteFile(created, []byte("b"), 0644))
+	clock.Advance(4 * time.Second)
+	// wait for the poll to set the debounce timer, and schedule the next poll
+	clock.BlockUntil(2)
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, []fsnotify.Event{{Name: created, Op: fsnotify.Create}}, batches[1])
+	assert.True(t, backend.IsWatched(created))
+
+	// errors are timed with the clock
+	clock.Advance(time.Minute)
+	backend.SendError(errors.New("failed"))
+	assert.Equal(t, clock.Now(), w.Stats().LastErrorTime)
+}
+
+func TestReplayJournal(t *testing.T) {
+	dir := t.TempDir()
+	event := func(name string, op fsnotify.Op) fsnotify.Event {
+		return fsnotify.Event{Name: filepath.Join(dir, name), Op: op}
+	}
+	opts := filewatcher.WatcherOptions{
+		Directories: []string{dir},
+		Include:     []string{"*.sp"},
+	}
+
+	// record a journal
+	var journal bytes.Buffer
+	var recorded [][]fsnotify.Event
+	recordOpts := opts
+	clock := NewFakeClock(time.Time{})
+	backend := NewFakeBackend()
+	recordOpts.Clock = clock
+	recordOpts.Backend = backend
+	recordOpts.Journal = &journal
+	recordOpts.OnChange = func(events []fsnotify.Event) { recorded = append(recorded, events) }
+	w, err := filewatcher.NewWatcher(&recordOpts)
+	assert.NoError(t, err)
+	w.Start()
+	backend.Send(event("a.sp", fsnotify.Create), event("b.txt", fsnotify.Create))
+	clock.Advance(50 * time.Millisecond)
+	backend.Send(event("a.sp", fsnotify.Write))
+	clock.Advance(time.Second)
+	// a new file is found by polling
+	created := filepath.Join(dir, "c.sp")
+	assert.NoError(t, os.WriteFile(created, []byte("c"), 0644))
+	clock.Advance(3 * time.Second)
+	// wait for the poll to set the debounce timer, and schedule the next poll
+	clock.BlockUntil(2)
+	clock.Advance(time.Second)
+	assert.NoError(t, w.Close(context.Background()))
+	assert.Equal(t, []fsnotify.Event{event("c.sp", fsnotify.Create)}, recorded[1])
+	assert.Len(t, recorded, 2)
+
+	entries, err := filewatcher.ReadJournal(bytes.NewReader(journal.Bytes()))
+	assert.NoError(t, err)
+	var filtered, raised []string
+	for _, e := range entries {
+		if e.Type == filewatcher.JournalEntryFilter && !e.Included {
+			filtered = append(filtered, e.Path+": "+e.Reason)
+		}
+		if e.Type == filewatcher.JournalEntryEvent && e.Source != filewatcher.JournalSourceBackend {
+			raised = append(raised, e.Path+": "+string(e.Source))
+		}
+	}
+	assert.Equal(t, []string{filepath.Join(dir, "b.txt") + ": excluded"}, filtered)
+	assert.Equal(t, []string{created + ": listing"}, raised)
+
+	// replaying the journal reproduces the batches - the events raised by polling are replayed from the journal,
+	// so the replay does not depend on the current files
+	assert.NoError(t, os.Remove(created))
+	replayed, err := ReplayJournal(&journal, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, recorded, replayed)
+}
@@ -0,0 +1,113 @@
+package filewatchertest
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/turbot/go-kit/filewatcher"
+)
+
+// after the last event of a journal is replayed, the clock is advanced by this period so any pending batches
+// are handled (this exceeds the default debounce, rename, storm and write stability windows)
+const replayFlushPeriod = 2 * time.Minute
+
+// ReplayJournal feeds the raw events recorded in a journal (see filewatcher.WatcherOptions.Journal) through a new
+// watcher created with the given options, and returns the batches passed to the handlers
+//
+// The recorded timing of the events is reproduced with a FakeClock, so the batching matches the recording.
+// The Clock and Backend options are replaced with a FakeClock and a filewatcher.ReplayBackend, and OnChange is
+// wrapped to collect the batches. The options should otherwise match those of the watcher which recorded the journal.
+//
+// The events which the recording watcher raised itself (for new files found by polling, InitialSnapshot, resyncs
+// and scope changes) are replayed from the journal, rather than being raised again by the replaying watcher.
+//
+// Note: the replay runs against the current file system, so decisions which depend on the state of the files
+// (e.g. IgnoreUnchangedContent and WriteStability) may differ from the recording.
+func ReplayJournal(journal io.Reader, opts filewatcher.WatcherOptions) ([][]fsnotify.Event, error) {
+	entries, err := filewatcher.ReadJournal(journal)
+	if err != nil {
+		return nil, err
+	}
+	var events []filewatcher.JournalEntry
+	for _, e := range entries {
+		if e.Type == filewatcher.JournalEntryEvent && e.JournalEvent != nil {
+			events = append(events, e)
+		}
+	}
+
+	var start time.Time
+	if len(events) > 0 {
+		start = events[0].Time
+	}
+	clock := NewFakeClock(start)
+	backend := &replayBackend{FakeBackend: NewFakeBackend(), raised: make(chan filewatcher.RaisedEvents)}
+
+	var lock sync.Mutex
+	var batches [][]fsnotify.Event
+	onChange := opts.OnChange
+	opts.OnChange = func(events []fsnotify.Event) {
+		lock.Lock()
+		batches = append(batches, events)
+		lock.Unlock()
+		if onChange != nil {
+			onChange(events)
+		}
+	}
+	opts.Clock = clock
+	opts.Backend = backend
+
+	w, err := filewatcher.NewWatcher(&opts)
+	if err != nil {
+		return nil, err
+	}
+	w.Start()
+	for i := 0; i < len(events); i++ {
+		e := events[i]
+		if gap := e.Time.Sub(clock.Now()); gap > 0 {
+			clock.Advance(gap)
+		}
+		if e.Source == "" || e.Source == filewatcher.JournalSourceBackend {
+			backend.Send(e.Event())
+			continue
+		}
+		// the events raised together have the same source and time
+		raised := filewatcher.RaisedEvents{Source: e.Source, Events: []fsnotify.Event{e.Event()}}
+		for ; i+1 < len(events) && events[i+1].Source == e.Source && events[i+1].Time.Equal(e.Time); i++ {
+			raised.Events = append(raised.Events, events[i+1].Event())
+		}
+		backend.raise(raised)
+	}
+	clock.Advance(replayFlushPeriod)
+	if err := w.Close(context.Background()); err != nil {
+		return nil, err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	return batches, nil
+}
+
+// replayBackend is a FakeBackend which also replays the events raised by the recording watcher
+type replayBackend struct {
+	*FakeBackend
+	raised chan filewatcher.RaisedEvents
+}
+
+func (b *replayBackend) RaisedEvents() <-chan filewatcher.RaisedEvents {
+	return b.raised
+}
+
+// raise passes the raised events to the watcher, and returns once the watcher has handled them
+func (b *replayBackend) raise(raised filewatcher.RaisedEvents) {
+	b.sendLock.Lock()
+	defer b.sendLock.Unlock()
+	select {
+	case b.raised <- raised:
+	case <-b.closed:
+		return
+	}
+	b.sync()
+}
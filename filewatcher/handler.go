@@ -0,0 +1,84 @@
+package filewatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// HandlerMode determines what happens when events arrive while a handler is running
+type HandlerMode int
+
+const (
+	// QueueEvents queues the events which arrive while a handler is running, and handles them in the next batch
+	QueueEvents HandlerMode = iota
+	// CancelAndRestart cancels the context passed to OnChangeContext when events arrive while it is running, and
+	// skips any handlers of the batch which have not yet been called. Once the handler returns, the handlers are
+	// called again with the events of the cancelled batch merged with the new events
+	CancelAndRestart
+)
+
+// HandlerPanicError is passed to OnError when a handler panics
+// the watcher recovers from the panic and continues to handle events
+type HandlerPanicError struct {
+	// the value passed to panic
+	Value any
+	// the stack of the handler goroutine at the time of the panic
+	Stack []byte
+}
+
+func (e *HandlerPanicError) Error() string {
+	return fmt.Sprintf("file watcher handler panicked: %v\n%s", e.Value, e.Stack)
+}
+
+// handlers are the functions which a batch of events is passed to
+type handlers struct {
+	onChange        func([]fsnotify.Event)
+	onChangeContext func(context.Context, []fsnotify.Event)
+	onChangeSet     func(ChangeSet)
+}
+
+// callHandlers passes the events to each of the handlers which are set
+// once ctx is cancelled (i.e. the batch is to be restarted), the remaining handlers are skipped
+func (w *FileWatcher) callHandlers(ctx context.Context, h handlers, events []fsnotify.Event) {
+	raw := clearStable(events)
+	if h.onChange != nil && ctx.Err() == nil {
+		w.callHandler(ctx, func(context.Context) { h.onChange(raw) })
+	}
+	if h.onChangeContext != nil && ctx.Err() == nil {
+		w.callHandler(ctx, func(ctx context.Context) { h.onChangeContext(ctx, raw) })
+	}
+	if h.onChangeSet != nil && ctx.Err() == nil {
+		// only call the handler if there is a net change
+		if changes := w.changeSet(events); len(changes) > 0 {
+			w.callHandler(ctx, func(context.Context) { h.onChangeSet(changes) })
+		}
+	}
+}
+
+// callHandler calls the handler with a context derived from parent, which is cancelled after the HandlerTimeout (if set)
+// a panic in the handler is recovered and reported to OnError as a HandlerPanicError
+func (w *FileWatcher) callHandler(parent context.Context, handler func(ctx context.Context)) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if w.handlerTimeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, w.handlerTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			w.reportError(&HandlerPanicError{Value: r, Stack: debug.Stack()})
+		}
+	}()
+	handler(ctx)
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		w.reportError(fmt.Errorf("file watcher handler did not complete within %s: %w", w.handlerTimeout, ctx.Err()))
+	}
+}
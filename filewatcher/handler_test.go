@@ -0,0 +1,132 @@
+package filewatcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerPanic(t *testing.T) {
+	errs := make(chan error, 10)
+	changes := make(chan ChangeSet, 10)
+	w := newTestWatcher(t, &WatcherOptions{
+		OnChange:    func([]fsnotify.Event) { panic("boom") },
+		OnChangeSet: func(c ChangeSet) { changes <- c },
+		OnError:     func(err error) { errs <- err },
+	})
+
+	w.scheduleHandler(testEvent("a.sp"))
+
+	// the panic is reported, and the other handlers are still called
+	var panicErr *HandlerPanicError
+	assert.True(t, errors.As(receive(t, errs), &panicErr))
+	assert.Equal(t, "boom", panicErr.Value)
+	assert.NotEmpty(t, panicErr.Stack)
+	assert.Len(t, receive(t, changes), 1)
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	errs := make(chan error, 10)
+	w := newTestWatcher(t, &WatcherOptions{
+		OnChangeContext: func(ctx context.Context, _ []fsnotify.Event) { <-ctx.Done() },
+		HandlerTimeout:  20 * time.Millisecond,
+		OnError:         func(err error) { errs <- err },
+	})
+
+	w.scheduleHandler(testEvent("a.sp"))
+	assert.ErrorIs(t, receive(t, errs), context.DeadlineExceeded)
+}
+
+func TestHandlersDoNotOverlap(t *testing.T) {
+	var running, overlapped atomic.Bool
+	release := make(chan struct{})
+	batches := make(chan []fsnotify.Event, 10)
+	w := newTestWatcher(t, &WatcherOptions{
+		OnChange: func(events []fsnotify.Event) {
+			if running.Swap(true) {
+				overlapped.Store(true)
+			}
+			batches <- events
+			if events[0].Name == "a.sp" {
+				<-release
+			}
+			running.Store(false)
+		},
+	})
+
+	w.scheduleHandler(testEvent("a.sp"))
+	assert.Equal(t, []fsnotify.Event{testEvent("a.sp")}, <-batches)
+
+	// events arriving while the handler is running are accepted, and queued for the next batch
+	done := make(chan struct{})
+	go func() {
+		w.scheduleHandler(testEvent("b.sp"))
+		w.scheduleHandler(testEvent("c.sp"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("event intake blocked by running handler")
+	}
+	// allow the next batch to become due while the handler is still running
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, []fsnotify.Event{testEvent("b.sp"), testEvent("c.sp")}, <-batches)
+	assert.False(t, overlapped.Load())
+}
+
+func TestCancelAndRestart(t *testing.T) {
+	started := make(chan []fsnotify.Event, 10)
+	cancelled := make(chan []fsnotify.Event, 10)
+	changes := make(chan ChangeSet, 10)
+	var journal bytes.Buffer
+	w := newTestWatcher(t, &WatcherOptions{
+		HandlerMode: CancelAndRestart,
+		Journal:     &journal,
+		OnChangeContext: func(ctx context.Context, events []fsnotify.Event) {
+			started <- events
+			if len(events) == 1 {
+				// the first run does not complete until it is cancelled
+				<-ctx.Done()
+				cancelled <- events
+			}
+		},
+		OnChangeSet: func(c ChangeSet) { changes <- c },
+	})
+
+	w.scheduleHandler(testEvent("a.sp"))
+	assert.Equal(t, []fsnotify.Event{testEvent("a.sp")}, <-started)
+
+	// a new event cancels the running handler
+	w.scheduleHandler(testEvent("b.sp"))
+	assert.Equal(t, []fsnotify.Event{testEvent("a.sp")}, receive(t, cancelled))
+
+	// the handlers are called again with the merged events
+	// (OnChangeSet was skipped for the cancelled batch)
+	assert.Equal(t, []fsnotify.Event{testEvent("a.sp"), testEvent("b.sp")}, <-started)
+	assert.Equal(t, ChangeSet{{Path: "a.sp", Type: Modified}, {Path: "b.sp", Type: Modified}}, receive(t, changes))
+
+	// the journal records each event in a single batch
+	entries, err := ReadJournal(bytes.NewReader(journal.Bytes()))
+	assert.NoError(t, err)
+	var batches [][]JournalEvent
+	for _, e := range entries {
+		if e.Type == JournalEntryBatch {
+			batches = append(batches, e.Events)
+		}
+	}
+	assert.Equal(t, [][]JournalEvent{{*newJournalEvent(testEvent("a.sp"))}, {*newJournalEvent(testEvent("b.sp"))}}, batches)
+	select {
+	case c := <-changes:
+		t.Fatalf("unexpected change set %v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
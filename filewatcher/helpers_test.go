@@ -0,0 +1,42 @@
+package filewatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestWatcher returns a watcher which is closed when the test completes
+// if not set, the watcher watches a temp directory, with a short debounce
+func newTestWatcher(t *testing.T, opts *WatcherOptions) *FileWatcher {
+	if len(opts.Directories) == 0 {
+		opts.Directories = []string{t.TempDir()}
+	}
+	if opts.Debounce == nil {
+		opts.Debounce = &Debounce{Quiet: 10 * time.Millisecond}
+	}
+	w, err := NewWatcher(opts)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = w.Close(context.Background()) })
+	return w
+}
+
+// receive returns the next value from the channel, failing the test if it does not arrive in time
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		var v T
+		t.Fatalf("timed out waiting for %T", v)
+		return v
+	}
+}
+
+func testEvent(name string) fsnotify.Event {
+	return fsnotify.Event{Name: name, Op: fsnotify.Write}
+}
@@ -0,0 +1,261 @@
+package filewatcher
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/turbot/go-kit/files"
+)
+
+// the names of the ignore files which are respected when RespectIgnoreFiles is set
+// rules in later files take precedence over rules in earlier files
+var ignoreFileNames = []string{".gitignore", ".ignore"}
+
+// ignoreRule is a single rule from an ignore file
+type ignoreRule struct {
+	// fnmatch pattern, relative to the directory containing the ignore file
+	pattern string
+	// the rule re-includes paths matched by an earlier rule
+	negate bool
+	// the rule only matches directories
+	dirOnly bool
+	// the pattern is matched against the path relative to the directory containing the ignore file,
+	// rather than against the name of the path
+	anchored bool
+}
+
+func (r ignoreRule) match(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		return files.Match(r.pattern, relPath)
+	}
+	return files.Match(r.pattern, filepath.Base(relPath))
+}
+
+// parseIgnoreFile parses the rules in an ignore file, in .gitignore format
+func parseIgnoreFile(path string) ([]ignoreRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	// skip blank lines and comments
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		// escaped leading character
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	// a leading or middle slash anchors the pattern to the directory containing the ignore file
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	if strings.Count(line, "**") > 1 {
+		// files.Match does not support more than one '**'
+		log.Printf("[TRACE] ignoring unsupported ignore pattern '%s'", line)
+		return ignoreRule{}, false
+	}
+	rule.pattern = filepath.FromSlash(line)
+	return rule, true
+}
+
+// ignoreRules holds the rules from the ignore files in a directory tree
+// the rules in an ignore file apply to the directory containing it and all of its descendants
+type ignoreRules struct {
+	lock sync.RWMutex
+	// the rules for each directory which contains ignore files, keyed by directory
+	rules map[string][]ignoreRule
+}
+
+func newIgnoreRules() *ignoreRules {
+	return &ignoreRules{rules: make(map[string][]ignoreRule)}
+}
+
+// load (re)loads the rules from the ignore files in the given directory
+func (r *ignoreRules) load(directory string) {
+	var rules []ignoreRule
+	for _, name := range ignoreFileNames {
+		fileRules, err := parseIgnoreFile(filepath.Join(directory, name))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("[TRACE] failed to read ignore file in '%s': %v", directory, err)
+			}
+			continue
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(rules) == 0 {
+		delete(r.rules, directory)
+		return
+	}
+	r.rules[directory] = rules
+}
+
+// loadDirectories loads the rules from the given directories, and returns the directories which are not ignored
+// parent directories are loaded before their children, so the rules of a parent apply to its children
+func (r *ignoreRules) loadDirectories(directories []string) []string {
+	sorted := append([]string{}, directories...)
+	sort.Strings(sorted)
+
+	var res []string
+	for _, d := range sorted {
+		if r.isIgnored(d, true) {
+			continue
+		}
+		r.load(d)
+		res = append(res, d)
+	}
+	return res
+}
+
+// isIgnored returns whether the path is ignored
+// as with git, a path is ignored if any of its parent directories are ignored
+func (r *ignoreRules) isIgnored(path string, isDir bool) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.rules) == 0 {
+		return false
+	}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if r.matchRules(dir, true) {
+			return true
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	return r.matchRules(path, isDir)
+}
+
+// matchRules applies the rules from the ignore files in all ancestor directories of the path
+// the last matching rule determines whether the path is ignored, and rules in deeper directories take precedence
+// must be called with the lock held
+func (r *ignoreRules) matchRules(path string, isDir bool) bool {
+	// find the directories with rules which apply to this path, deepest first
+	var ruleDirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, ok := r.rules[dir]; ok {
+			ruleDirs = append(ruleDirs, dir)
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+
+	for _, dir := range ruleDirs {
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			continue
+		}
+		rules := r.rules[dir]
+		for i := len(rules) - 1; i >= 0; i-- {
+			if rules[i].match(relPath, isDir) {
+				return !rules[i].negate
+			}
+		}
+	}
+	return false
+}
+
+// isIgnoreFile returns whether the path is an ignore file
+func isIgnoreFile(path string) bool {
+	name := filepath.Base(path)
+	for _, n := range ignoreFileNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnored returns whether the path is ignored by the rules in the ignore files
+// (this is always false unless RespectIgnoreFiles is set)
+func (w *FileWatcher) isIgnored(path string, isDir bool) bool {
+	return w.ignore != nil && w.ignore.isIgnored(path, isDir)
+}
+
+// listFiles lists the files in the directory, excluding any which are ignored by the ignore files
+func (w *FileWatcher) listFiles(directory string, opts *files.ListOptions) ([]string, error) {
+	paths, err := files.ListFiles(directory, opts)
+	if err != nil || w.ignore == nil {
+		return paths, err
+	}
+	var res []string
+	for _, p := range paths {
+		if !w.isIgnored(p, false) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// ignoreFiles returns the ignore files which exist in the directory
+// (this is always empty unless RespectIgnoreFiles is set)
+func (w *FileWatcher) ignoreFiles(directory string) []string {
+	if w.ignore == nil {
+		return nil
+	}
+	var res []string
+	for _, name := range ignoreFileNames {
+		p := filepath.Join(directory, name)
+		if files.FileExists(p) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// reloadIgnoreFile reloads the rules of the directory containing a changed ignore file, then updates the
+// watched directories and watches to match
+func (w *FileWatcher) reloadIgnoreFile(ev fsnotify.Event) {
+	if ev.Op == fsnotify.Chmod {
+		return
+	}
+	log.Printf("[TRACE] ignore file changed: '%s' - reload rules", ev.Name)
+	w.updateScope(func() {
+		if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+			// the watch has gone - clear the flag so a replacement file is watched
+			w.watches[ev.Name] = false
+		}
+		w.ignore.load(filepath.Dir(ev.Name))
+	})
+}
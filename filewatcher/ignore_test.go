@@ -0,0 +1,94 @@
+package filewatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/go-kit/files"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	assert.NoError(t, os.MkdirAll(sub, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, ".gitignore"), []byte(`
+# comment
+*.log
+!keep.log
+build/
+/top.txt
+docs/*.md
+`), 0644))
+	// rules in a child directory take precedence, and .ignore takes precedence over .gitignore
+	assert.NoError(t, os.WriteFile(filepath.Join(sub, ".gitignore"), []byte("!*.log\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(sub, ".ignore"), []byte("debug.log\n"), 0644))
+
+	rules := newIgnoreRules()
+	rules.loadDirectories([]string{sub, root})
+
+	tests := map[string]struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		"pattern":                     {path: "a.log", want: true},
+		"pattern in child directory":  {path: "x/a.log", want: true},
+		"negation":                    {path: "keep.log", want: false},
+		"not matched":                 {path: "a.txt", want: false},
+		"directory only":              {path: "build", isDir: true, want: true},
+		"directory only file":         {path: "build", want: false},
+		"file in ignored directory":   {path: "build/a.txt", want: true},
+		"anchored":                    {path: "top.txt", want: true},
+		"anchored in child directory": {path: "x/top.txt", want: false},
+		"middle slash":                {path: "docs/a.md", want: true},
+		"middle slash nested":         {path: "x/docs/a.md", want: false},
+		"child negation":              {path: "sub/a.log", want: false},
+		"child .ignore":               {path: "sub/debug.log", want: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(root, filepath.FromSlash(test.path))
+			assert.Equal(t, test.want, rules.isIgnored(path, test.isDir))
+		})
+	}
+}
+
+func TestRespectIgnoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	build := filepath.Join(dir, "build")
+	assert.NoError(t, os.MkdirAll(build, 0755))
+	a := filepath.Join(dir, "a.sp")
+	b := filepath.Join(dir, "b.sp")
+	c := filepath.Join(build, "c.sp")
+	for _, p := range []string{a, b, c} {
+		assert.NoError(t, os.WriteFile(p, []byte(p), 0644))
+	}
+	gitignore := filepath.Join(dir, ".gitignore")
+	assert.NoError(t, os.WriteFile(gitignore, []byte("build/\nb.sp\n"), 0644))
+
+	w, err := NewWatcher(&WatcherOptions{
+		Directories:        []string{dir},
+		Include:            []string{"**/*.sp"},
+		ListFlag:           files.FilesRecursive,
+		RespectIgnoreFiles: true,
+	})
+	assert.NoError(t, err)
+	w.Start()
+	defer w.Close(context.Background())
+
+	// ignored files and directories are not watched, but the ignore file is
+	assert.Equal(t, map[string]bool{a: true, gitignore: true}, w.watches)
+	assert.Equal(t, map[string]bool{dir: true}, w.directories)
+
+	// changing the ignore file reloads the rules
+	assert.NoError(t, os.WriteFile(gitignore, []byte("a.sp\n"), 0644))
+	assert.Eventually(t, func() bool {
+		w.dirLock.Lock()
+		defer w.dirLock.Unlock()
+		return w.watches[b] && w.watches[c] && !w.watches[a] && w.directories[build]
+	}, 2*time.Second, 10*time.Millisecond)
+}
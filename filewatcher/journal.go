@@ -0,0 +1,175 @@
+package filewatcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// JournalEntryType is the type of a journal entry
+type JournalEntryType string
+
+const (
+	// JournalEntryEvent is a raw event received from the backend
+	JournalEntryEvent JournalEntryType = "event"
+	// JournalEntryFilter is the decision whether to publish an event for a file
+	JournalEntryFilter JournalEntryType = "filter"
+	// JournalEntryBatch is a batch of events passed to the handlers
+	JournalEntryBatch JournalEntryType = "batch"
+)
+
+// JournalSource is the source of an event entry
+type JournalSource string
+
+const (
+	// JournalSourceBackend is an event received from the backend
+	JournalSourceBackend JournalSource = "backend"
+	// JournalSourceListing is a Create event raised for a new file found by listing a watched directory
+	// (when polling, or when a directory is created)
+	JournalSourceListing JournalSource = "listing"
+	// JournalSourceSnapshot is an event raised for a change since WatcherOptions.InitialSnapshot
+	JournalSourceSnapshot JournalSource = "snapshot"
+	// JournalSourceResync is an event raised for a missed change found when resynchronising (see ResyncError)
+	JournalSourceResync JournalSource = "resync"
+	// JournalSourceScope is an event raised for a file entering or leaving scope (see WatcherOptions.EmitScopeChanges)
+	JournalSourceScope JournalSource = "scope"
+)
+
+// JournalEntry is a single line of a journal
+type JournalEntry struct {
+	Time time.Time        `json:"time"`
+	Type JournalEntryType `json:"type"`
+
+	// the event, for event and filter entries
+	*JournalEvent
+	// for event entries, whether the event was received from the backend or raised by the watcher itself
+	Source JournalSource `json:"source,omitempty"`
+
+	// for filter entries, whether the event was published, and if not, why not
+	Included bool   `json:"included,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+
+	// the events of a batch entry
+	Events []JournalEvent `json:"events,omitempty"`
+}
+
+// JournalEvent is an event recorded in a journal
+type JournalEvent struct {
+	Path string `json:"path"`
+	Op   uint32 `json:"op"`
+	// the readable form of Op
+	OpName string `json:"op_name"`
+}
+
+func newJournalEvent(ev fsnotify.Event) *JournalEvent {
+	return &JournalEvent{
+		Path:   ev.Name,
+		Op:     uint32(ev.Op),
+		OpName: ev.Op.String(),
+	}
+}
+
+// Event returns the recorded event
+func (e JournalEvent) Event() fsnotify.Event {
+	return fsnotify.Event{Name: e.Path, Op: fsnotify.Op(e.Op)}
+}
+
+// RaisedEvents are events which were raised together by a watcher itself, rather than received from its backend
+type RaisedEvents struct {
+	Source JournalSource
+	Events []fsnotify.Event
+}
+
+// ReplayBackend is a Backend which replays a journal (see filewatchertest.ReplayJournal)
+//
+// A watcher with a ReplayBackend does not raise events itself (e.g. for new files found by polling), as the
+// journal records the events raised when it was recorded - these are replayed by the backend instead.
+type ReplayBackend interface {
+	Backend
+	// RaisedEvents returns the channel on which the events raised by the recording watcher are published
+	RaisedEvents() <-chan RaisedEvents
+}
+
+// ReadJournal reads the entries of a journal written by a FileWatcher
+func ReadJournal(r io.Reader) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(r)
+	// batch entries may be long
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse journal line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// journal writes entries as JSON lines
+type journal struct {
+	clock Clock
+
+	lock    sync.Mutex
+	encoder *json.Encoder
+	// set once a write has failed, so the error is only logged once
+	failed bool
+}
+
+func newJournal(w io.Writer, clock Clock) *journal {
+	return &journal{
+		clock:   clock,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (j *journal) write(entry JournalEntry) {
+	entry.Time = j.clock.Now()
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if err := j.encoder.Encode(entry); err != nil && !j.failed {
+		j.failed = true
+		log.Printf("[WARN] failed to write file watcher journal: %v", err)
+	}
+}
+
+// journalEvent records a raw event received from the backend, or raised by the watcher itself
+func (w *FileWatcher) journalEvent(ev fsnotify.Event, source JournalSource) {
+	if w.journal != nil {
+		w.journal.write(JournalEntry{Type: JournalEntryEvent, JournalEvent: newJournalEvent(ev), Source: source})
+	}
+}
+
+// journalFilter records the decision whether to publish an event for a file
+// reason is set if the event is not published
+func (w *FileWatcher) journalFilter(ev fsnotify.Event, reason string) {
+	if w.journal != nil {
+		w.journal.write(JournalEntry{
+			Type:         JournalEntryFilter,
+			JournalEvent: newJournalEvent(ev),
+			Included:     reason == "",
+			Reason:       reason,
+		})
+	}
+}
+
+// journalBatch records a batch of events passed to the handlers
+func (w *FileWatcher) journalBatch(events []fsnotify.Event) {
+	if w.journal != nil {
+		entry := JournalEntry{Type: JournalEntryBatch}
+		for _, ev := range events {
+			entry.Events = append(entry.Events, *newJournalEvent(ev))
+		}
+		w.journal.write(entry)
+	}
+}
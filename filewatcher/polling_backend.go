@@ -0,0 +1,209 @@
+package filewatcher
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/turbot/go-kit/files"
+)
+
+const defaultBackendPollInterval = time.Second
+
+// PollingBackend is a Backend which detects changes by periodically comparing stat snapshots
+// of the watched paths, rather than relying on operating system notifications
+//
+// As with fsnotify, watching a directory reports changes to the directory and its direct children,
+// and watching a file reports changes to that file.
+// Renames are reported as a Remove of the old path followed by a Create of the new path.
+type PollingBackend struct {
+	interval time.Duration
+
+	events    chan fsnotify.Event
+	errors    chan error
+	closeChan chan struct{}
+	closeOnce sync.Once
+
+	// map of watched path to the snapshot taken at the last poll
+	watches   map[string]map[string]SnapshotEntry
+	watchLock sync.Mutex
+}
+
+// NewPollingBackend returns a PollingBackend which polls the watched paths every interval
+// if interval is zero, a default of 1 second is used
+func NewPollingBackend(interval time.Duration) *PollingBackend {
+	if interval <= 0 {
+		interval = defaultBackendPollInterval
+	}
+	b := &PollingBackend{
+		interval:  interval,
+		events:    make(chan fsnotify.Event),
+		errors:    make(chan error),
+		closeChan: make(chan struct{}),
+		watches:   make(map[string]map[string]SnapshotEntry),
+	}
+	go b.poll()
+	return b
+}
+
+func (b *PollingBackend) Add(path string) error {
+	if b.isClosed() {
+		return fsnotify.ErrClosed
+	}
+	snapshot, err := b.scan(path)
+	if err != nil {
+		return err
+	}
+
+	b.watchLock.Lock()
+	defer b.watchLock.Unlock()
+	// if we are already watching, leave the existing snapshot in place so we do not lose any changes
+	if _, ok := b.watches[path]; !ok {
+		b.watches[path] = snapshot
+	}
+	return nil
+}
+
+func (b *PollingBackend) Remove(path string) error {
+	b.watchLock.Lock()
+	defer b.watchLock.Unlock()
+
+	if _, ok := b.watches[path]; !ok {
+		return fmt.Errorf("%w: %s", fsnotify.ErrNonExistentWatch, path)
+	}
+	delete(b.watches, path)
+	return nil
+}
+
+func (b *PollingBackend) Events() <-chan fsnotify.Event {
+	return b.events
+}
+
+func (b *PollingBackend) Errors() <-chan error {
+	return b.errors
+}
+
+func (b *PollingBackend) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closeChan)
+	})
+	return nil
+}
+
+func (b *PollingBackend) isClosed() bool {
+	select {
+	case <-b.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *PollingBackend) poll() {
+	// close the channels when the poll loop exits, as fsnotify does
+	defer close(b.events)
+	defer close(b.errors)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// do not hold the lock while sending - the receiver may call Add or Remove
+			events, errors := b.rescan()
+			for _, err := range errors {
+				if !b.sendError(err) {
+					return
+				}
+			}
+			for _, ev := range events {
+				if !b.sendEvent(ev) {
+					return
+				}
+			}
+		case <-b.closeChan:
+			return
+		}
+	}
+}
+
+// rescan takes a new snapshot of each watched path, updates the stored snapshots
+// and returns the events for any changes
+func (b *PollingBackend) rescan() ([]fsnotify.Event, []error) {
+	b.watchLock.Lock()
+	defer b.watchLock.Unlock()
+
+	// scan in a consistent order so events are raised in a consistent order
+	paths := make([]string, 0, len(b.watches))
+	for path := range b.watches {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var events []fsnotify.Event
+	var errors []error
+	for _, path := range paths {
+		previous := b.watches[path]
+		current, err := b.scan(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errors = append(errors, err)
+				continue
+			}
+			// the watched path has been removed - as with fsnotify, the watch is removed
+			current = nil
+			delete(b.watches, path)
+		} else {
+			b.watches[path] = current
+		}
+		events = append(events, diffSnapshotEntries(previous, current)...)
+	}
+	return events, errors
+}
+
+// scan returns the file state of the given path and, if it is a directory, the state of its direct children
+func (b *PollingBackend) scan(path string) (map[string]SnapshotEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := map[string]SnapshotEntry{path: newSnapshotEntry(info)}
+	if !info.IsDir() {
+		return snapshot, nil
+	}
+
+	children, err := files.ListFiles(path, &files.ListOptions{Flags: files.AllFlat})
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		childInfo, err := os.Stat(child)
+		if err != nil {
+			// the child may have been removed since we listed the directory
+			continue
+		}
+		snapshot[child] = newSnapshotEntry(childInfo)
+	}
+	return snapshot, nil
+}
+
+func (b *PollingBackend) sendEvent(ev fsnotify.Event) bool {
+	select {
+	case b.events <- ev:
+		return true
+	case <-b.closeChan:
+		return false
+	}
+}
+
+func (b *PollingBackend) sendError(err error) bool {
+	select {
+	case b.errors <- err:
+		return true
+	case <-b.closeChan:
+		return false
+	}
+}
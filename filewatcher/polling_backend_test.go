@@ -0,0 +1,40 @@
+package filewatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollingBackend(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.sp")
+	assert.NoError(t, os.WriteFile(existing, []byte("a"), 0644))
+
+	backend := NewPollingBackend(10 * time.Millisecond)
+	defer backend.Close()
+	assert.NoError(t, backend.Add(dir))
+
+	created := filepath.Join(dir, "new.sp")
+	assert.NoError(t, os.WriteFile(created, []byte("b"), 0644))
+	assert.NoError(t, os.Remove(existing))
+
+	received := map[string]fsnotify.Op{}
+	timeout := time.After(2 * time.Second)
+	for len(received) < 2 {
+		select {
+		case ev := <-backend.Events():
+			received[ev.Name] |= ev.Op
+		case err := <-backend.Errors():
+			t.Fatalf("unexpected error: %v", err)
+		case <-timeout:
+			t.Fatalf("timed out waiting for events, received %v", received)
+		}
+	}
+	assert.Equal(t, fsnotify.Create, received[created])
+	assert.Equal(t, fsnotify.Remove, received[existing])
+}
@@ -0,0 +1,157 @@
+package filewatcher
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// errBackendStopped is the cause of a resync after the backend stopped unexpectedly
+var errBackendStopped = errors.New("file watcher backend stopped unexpectedly")
+
+// ResyncError is passed to OnError when the watcher has resynchronised with the file system after events may
+// have been lost - either because the event queue overflowed or because the backend stopped and was recreated
+//
+// The watched directories are rescanned and their watches rebuilt. If WatcherOptions.ResyncMissedEvents is set,
+// the files in scope are compared with the state known to the watcher, and events for any missed changes are
+// published as normal, so no action is required by the caller - otherwise the caller should reload any state
+// which depends on the watched files.
+type ResyncError struct {
+	// the reason for the resync, e.g. fsnotify.ErrEventOverflow
+	Cause error
+	// whether the backend was recreated as it had stopped
+	BackendRecreated bool
+	// the number of events raised for the missed changes
+	Events int
+}
+
+func (e *ResyncError) Error() string {
+	return fmt.Sprintf("file watcher resynchronised after error: %v (%d missed events)", e.Cause, e.Events)
+}
+
+func (e *ResyncError) Unwrap() error {
+	return e.Cause
+}
+
+// captureKnownState captures the state of the files in scope, to compare against when resynchronising
+func (w *FileWatcher) captureKnownState() {
+	known, err := w.Snapshot(false)
+	if err != nil {
+		log.Printf("[TRACE] failed to capture file watcher state: %v", err)
+		return
+	}
+	w.knownLock.Lock()
+	defer w.knownLock.Unlock()
+	w.known = known
+}
+
+// updateKnownState applies the events which have passed our filters to the known state
+func (w *FileWatcher) updateKnownState(events []fsnotify.Event) {
+	w.knownLock.Lock()
+	defer w.knownLock.Unlock()
+	if w.known == nil {
+		return
+	}
+	for _, ev := range events {
+		if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+			delete(w.known.Entries, ev.Name)
+			continue
+		}
+		info, err := os.Stat(ev.Name)
+		if err != nil {
+			delete(w.known.Entries, ev.Name)
+			continue
+		}
+		if !info.IsDir() {
+			w.known.Entries[ev.Name] = newSnapshotEntry(info)
+		}
+	}
+}
+
+// resync rescans the watched directories, publishes events for any changes which differ from the known state,
+// then reports a ResyncError
+func (w *FileWatcher) resync(cause error, backendRecreated bool) {
+	log.Printf("[TRACE] file watcher resync: %v", cause)
+
+	// directory events may have been lost, so rebuild the watched directories and watches
+	created := w.updateScope(func() {})
+
+	if !w.resyncMissedEvents {
+		w.reportError(&ResyncError{Cause: cause, BackendRecreated: backendRecreated})
+		return
+	}
+
+	current, err := w.Snapshot(false)
+	if err != nil {
+		w.reportError(fmt.Errorf("failed to resynchronise file watcher after error: %v: %w", cause, err))
+		return
+	}
+	w.knownLock.Lock()
+	var missed []fsnotify.Event
+	if w.known != nil {
+		missed = w.known.events(current)
+	}
+	w.known = current
+	w.knownLock.Unlock()
+
+	var events []fsnotify.Event
+	for _, ev := range missed {
+		if ev.Has(fsnotify.Create) && slices.Contains(created, ev.Name) {
+			// the scope update has already raised a create event for this file
+			continue
+		}
+		if w.contentChanged(ev) {
+			events = append(events, ev)
+		}
+	}
+	w.raiseEvents(JournalSourceResync, events)
+	w.reportError(&ResyncError{
+		Cause:            cause,
+		BackendRecreated: backendRecreated,
+		Events:           len(events),
+	})
+}
+
+// recreateBackend replaces a backend which has stopped unexpectedly, then resynchronises
+// returns false if the backend cannot be recreated (a backend passed as WatcherOptions.Backend is not recreated)
+func (w *FileWatcher) recreateBackend() bool {
+	if w.newBackend == nil {
+		return false
+	}
+	select {
+	case <-w.closeChan:
+		return false
+	default:
+	}
+
+	backend, err := w.newBackend()
+	if err != nil {
+		w.reportError(fmt.Errorf("failed to recreate file watcher backend: %w", err))
+		return false
+	}
+	w.dirLock.Lock()
+	old := w.watch
+	w.watch = backend
+	// the watches were lost with the old backend - add them to the new backend, so that only files created
+	// since the last poll are reported as created by the resync
+	for p, watched := range w.watches {
+		if !watched {
+			continue
+		}
+		if err := backend.Add(p); err != nil {
+			log.Printf("[TRACE] error occurred setting watches: %v", err)
+			delete(w.watches, p)
+		}
+	}
+	w.dirLock.Unlock()
+	if err := old.Close(); err != nil {
+		log.Printf("[TRACE] error closing stopped file watcher backend: %v", err)
+	}
+
+	w.resync(errBackendStopped, true)
+	return true
+}
@@ -0,0 +1,106 @@
+package filewatcher_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/go-kit/filewatcher"
+	"github.com/turbot/go-kit/filewatcher/filewatchertest"
+)
+
+func TestResync(t *testing.T) {
+	tests := map[string]struct {
+		// causes the resync
+		trigger          func(b *filewatchertest.FakeBackend)
+		missedEvents     bool
+		wantCause        string
+		backendRecreated bool
+	}{
+		"overflow": {
+			trigger:      func(b *filewatchertest.FakeBackend) { b.SendError(fsnotify.ErrEventOverflow) },
+			missedEvents: true,
+			wantCause:    fsnotify.ErrEventOverflow.Error(),
+		},
+		"backend stopped": {
+			trigger:          func(b *filewatchertest.FakeBackend) { b.Close() },
+			missedEvents:     true,
+			wantCause:        "file watcher backend stopped unexpectedly",
+			backendRecreated: true,
+		},
+		"overflow without missed events": {
+			trigger:   func(b *filewatchertest.FakeBackend) { b.SendError(fsnotify.ErrEventOverflow) },
+			wantCause: fsnotify.ErrEventOverflow.Error(),
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := filepath.Join(dir, "a.sp")
+			b := filepath.Join(dir, "b.sp")
+			assert.NoError(t, os.WriteFile(a, []byte("a"), 0644))
+
+			clock := filewatchertest.NewFakeClock(time.Time{})
+			backends := []*filewatchertest.FakeBackend{filewatchertest.NewFakeBackend(), filewatchertest.NewFakeBackend()}
+			created := 0
+			// the handlers are called by FakeClock.Advance, and errors by the event loop while the backend
+			// is handling an event, so do not need to be synchronised
+			var changes []filewatcher.ChangeSet
+			var resyncs []*filewatcher.ResyncError
+			w, err := filewatcher.NewWatcher(&filewatcher.WatcherOptions{
+				Directories: []string{dir},
+				Include:     []string{"*.sp"},
+				Clock:       clock,
+				NewBackend: func() (filewatcher.Backend, error) {
+					created++
+					return backends[created-1], nil
+				},
+				ResyncMissedEvents: test.missedEvents,
+				OnChangeSet:        func(c filewatcher.ChangeSet) { changes = append(changes, c) },
+				OnError: func(err error) {
+					var resyncErr *filewatcher.ResyncError
+					if errors.As(err, &resyncErr) {
+						resyncs = append(resyncs, resyncErr)
+					}
+				},
+			})
+			assert.NoError(t, err)
+			w.Start()
+			defer w.Close(context.Background())
+
+			// make changes which the backend does not report
+			assert.NoError(t, os.Remove(a))
+			assert.NoError(t, os.WriteFile(b, []byte("b"), 0644))
+			test.trigger(backends[0])
+			current := backends[0]
+			if test.backendRecreated {
+				current = backends[1]
+			}
+			// the watcher resyncs before it receives from the replacement backend
+			current.Sync()
+			clock.Advance(time.Second)
+
+			if !assert.Len(t, resyncs, 1) {
+				return
+			}
+			resyncErr := resyncs[0]
+			assert.EqualError(t, resyncErr.Cause, test.wantCause)
+			assert.Equal(t, test.backendRecreated, resyncErr.BackendRecreated)
+			// the new file is found by listing, as it would be by the next poll, so is reported either way
+			if test.missedEvents {
+				assert.Equal(t, 1, resyncErr.Events)
+				assert.Equal(t, []filewatcher.ChangeSet{{{Path: a, Type: filewatcher.Deleted}, {Path: b, Type: filewatcher.Added}}}, changes)
+			} else {
+				assert.Equal(t, 0, resyncErr.Events)
+				assert.Equal(t, []filewatcher.ChangeSet{{{Path: b, Type: filewatcher.Added}}}, changes)
+			}
+			// the watches are rebuilt
+			assert.True(t, current.IsWatched(b))
+		})
+	}
+}
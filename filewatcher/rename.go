@@ -0,0 +1,156 @@
+package filewatcher
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// allow a short delay for the create event of the new path of a renamed file
+const defaultRenameWindow = handlerDelay
+
+// fileID identifies a file by device and inode, so a file can be recognised after it is renamed
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+// renameTracker pairs the Rename event for the old path of a file with the Create event for the new path
+//
+// fsnotify does not expose the inotify rename cookie, so the paths are correlated using the device and inode
+// of the file. On platforms where these are not available, renames are not paired.
+type renameTracker struct {
+	window time.Duration
+	clock  Clock
+
+	lock sync.Mutex
+	// the file id of each known path
+	ids map[string]fileID
+	// renames waiting for the create event of the new path, keyed by file id
+	pending map[fileID]*pendingRename
+	// once closed, renames are no longer held
+	closed bool
+}
+
+type pendingRename struct {
+	event fsnotify.Event
+	timer Timer
+}
+
+func newRenameTracker(window time.Duration, clock Clock) *renameTracker {
+	if window <= 0 {
+		window = defaultRenameWindow
+	}
+	return &renameTracker{
+		window:  window,
+		clock:   clock,
+		ids:     make(map[string]fileID),
+		pending: make(map[fileID]*pendingRename),
+	}
+}
+
+// record stores the file id of the given path
+func (t *renameTracker) record(path string) {
+	id, ok := statFileID(path)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !ok {
+		delete(t.ids, path)
+		return
+	}
+	t.ids[path] = id
+}
+
+// remove forgets the file id of the given path
+func (t *renameTracker) remove(path string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.ids, path)
+}
+
+// rename holds the rename event for up to the rename window, waiting for the create event for the new path
+// if the create event does not arrive in time, release is called with the rename event
+// returns false if the file id of the old path is not known, in which case the rename cannot be paired
+func (t *renameTracker) rename(ev fsnotify.Event, release func(...fsnotify.Event)) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.closed {
+		return false
+	}
+	id, ok := t.ids[ev.Name]
+	if !ok {
+		return false
+	}
+	delete(t.ids, ev.Name)
+
+	p := &pendingRename{event: ev}
+	if existing, ok := t.pending[id]; ok && existing.timer.Stop() {
+		// this should not happen, but do not lose the earlier rename
+		go release(existing.event)
+	}
+	t.pending[id] = p
+	p.timer = t.clock.AfterFunc(t.window, func() {
+		t.lock.Lock()
+		current := t.pending[id] == p
+		if current {
+			delete(t.pending, id)
+		}
+		t.lock.Unlock()
+		if current {
+			release(p.event)
+		}
+	})
+	return true
+}
+
+// close stops the tracker holding renames
+// renames which are waiting for the create event of the new path are either discarded or released immediately,
+// according to the policy
+func (t *renameTracker) close(policy PendingEventPolicy, release func(...fsnotify.Event)) {
+	t.lock.Lock()
+	t.closed = true
+	var events []fsnotify.Event
+	for id, p := range t.pending {
+		// the timer function only releases the rename if it is still pending, so we can take it even if it has fired
+		p.timer.Stop()
+		events = append(events, p.event)
+		delete(t.pending, id)
+	}
+	t.lock.Unlock()
+
+	if policy == DrainPendingEvents && len(events) > 0 {
+		release(events...)
+	}
+}
+
+// create records the file id of the created path and returns the rename event which it pairs with, if any
+func (t *renameTracker) create(path string) (fsnotify.Event, bool) {
+	id, ok := statFileID(path)
+	if !ok {
+		return fsnotify.Event{}, false
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.ids[path] = id
+	p, ok := t.pending[id]
+	if !ok || !p.timer.Stop() {
+		// no rename is pending, or it has already been released
+		return fsnotify.Event{}, false
+	}
+	delete(t.pending, id)
+	return p.event, true
+}
+
+func statFileID(path string) (fileID, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileID{}, false
+	}
+	return getFileID(info)
+}
@@ -0,0 +1,113 @@
+package filewatcher
+
+import (
+	"context"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/turbot/go-kit/files"
+)
+
+// RouteDispatch determines which routes an event is passed to when its path matches more than one route
+type RouteDispatch int
+
+const (
+	// FirstMatchingRoute passes each event to the first route (in the order of WatcherOptions.Routes) which matches its path
+	FirstMatchingRoute RouteDispatch = iota
+	// AllMatchingRoutes passes each event to every route which matches its path
+	AllMatchingRoutes
+)
+
+// Route passes the events for paths matching its patterns to its own handlers
+// events are batched separately for each route, according to the route Debounce
+type Route struct {
+	// .gitignore (fnmatch) format patterns for the paths handled by this route
+	// relative patterns are resolved against each of the watched directories
+	Patterns []string
+	// the handlers for the events of this route - any or all may be set
+	// the handlers of a route never overlap, but may run at the same time as the handlers of other routes
+	// (see WatcherOptions.OnChangeContext)
+	OnChange        func([]fsnotify.Event)
+	OnChangeContext func(context.Context, []fsnotify.Event)
+	OnChangeSet     func(ChangeSet)
+	// controls how the events of this route are gathered into batches
+	// if not set, the handlers are called 100ms after the first event of a batch
+	Debounce *Debounce
+}
+
+// route is a Route with its patterns resolved against the watched directories
+type route struct {
+	Route
+	// the patterns resolved against each of the root directories
+	patterns []string
+	batcher  *batcher
+}
+
+func newRoute(w *FileWatcher, r Route) *route {
+	res := &route{Route: r}
+	res.batcher = newBatcher(r.Debounce, w.handlerMode, w.clock, func(ctx context.Context, events, restarted []fsnotify.Event) {
+		start := w.clock.Now()
+		defer func() {
+			w.stats.batchDelivered(len(events), w.clock.Now().Sub(start))
+		}()
+		w.callHandlers(ctx, handlers{
+			onChange:        res.OnChange,
+			onChangeContext: res.OnChangeContext,
+			onChangeSet:     res.OnChangeSet,
+		}, concatEvents(restarted, events))
+	})
+	return res
+}
+
+// match returns whether the path matches any of the route patterns
+func (r *route) match(path string) bool {
+	for _, p := range r.patterns {
+		if files.Match(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatchToRoutes passes each event to the batchers of the routes which match its path
+func (w *FileWatcher) dispatchToRoutes(events []fsnotify.Event) {
+	if len(w.routes) == 0 {
+		return
+	}
+
+	// the route patterns are updated when the watched directories change
+	w.dirLock.Lock()
+	routeEvents := make(map[*route][]fsnotify.Event)
+	for _, ev := range events {
+		for _, r := range w.routes {
+			if !r.match(ev.Name) {
+				continue
+			}
+			routeEvents[r] = append(routeEvents[r], ev)
+			if w.routeDispatch == FirstMatchingRoute {
+				break
+			}
+		}
+	}
+	w.dirLock.Unlock()
+
+	for r, events := range routeEvents {
+		r.batcher.add(events...)
+	}
+}
+
+// resolveRoutes resolves the route patterns against the root directories
+// must be called with the dirLock held
+func (w *FileWatcher) resolveRoutes() {
+	for _, r := range w.routes {
+		r.patterns = files.ResolveGlobRoots(r.Patterns, w.rootDirectories...)
+	}
+}
+
+// batchers returns the batchers of the watcher and all routes
+func (w *FileWatcher) batchers() []*batcher {
+	res := []*batcher{w.batcher}
+	for _, r := range w.routes {
+		res = append(res, r.batcher)
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+package filewatcher
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutes(t *testing.T) {
+	dir := t.TempDir()
+	mod := filepath.Join(dir, "mod.sp")
+	query := filepath.Join(dir, "query.sp")
+	config := filepath.Join(dir, "config.spc")
+	other := filepath.Join(dir, "other.txt")
+
+	tests := map[string]struct {
+		dispatch RouteDispatch
+		want     map[string][]string
+	}{
+		"first match": {
+			dispatch: FirstMatchingRoute,
+			want: map[string][]string{
+				"mod":    {mod},
+				"sp":     {query},
+				"config": {config},
+			},
+		},
+		"all matches": {
+			dispatch: AllMatchingRoutes,
+			want: map[string][]string{
+				"mod":    {mod},
+				"sp":     {mod, query},
+				"config": {config},
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			received := make(chan map[string][]string, 10)
+			route := func(name string, patterns ...string) Route {
+				return Route{
+					Patterns: patterns,
+					OnChange: func(events []fsnotify.Event) {
+						var paths []string
+						for _, ev := range events {
+							paths = append(paths, ev.Name)
+						}
+						received <- map[string][]string{name: paths}
+					},
+				}
+			}
+			w, err := NewWatcher(&WatcherOptions{
+				Directories: []string{dir},
+				Include:     []string{"*"},
+				Routes: []Route{
+					route("mod", "mod.sp"),
+					route("sp", "*.sp"),
+					route("config", "*.spc"),
+				},
+				RouteDispatch: test.dispatch,
+			})
+			assert.NoError(t, err)
+
+			for _, p := range []string{mod, query, config, other} {
+				w.scheduleHandler(fsnotify.Event{Name: p, Op: fsnotify.Write})
+			}
+
+			got := map[string][]string{}
+			for len(got) < len(test.want) {
+				select {
+				case batch := <-received:
+					for k, v := range batch {
+						got[k] = v
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("timed out waiting for route batches, received %v", got)
+				}
+			}
+			assert.Equal(t, test.want, got)
+			assert.NoError(t, w.Close(context.Background()))
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package filewatcher
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/turbot/go-kit/files"
+)
+
+// AddDirectory adds a directory to the watched directories
+// watches are added immediately, and the inclusions and exclusions are resolved against the new directory
+func (w *FileWatcher) AddDirectory(name string) error {
+	name = filepath.Clean(name)
+	if !files.DirectoryExists(name) {
+		return fmt.Errorf("'%s' is not a directory", name)
+	}
+	w.updateScope(func() {
+		if !slices.Contains(w.rootDirectories, name) {
+			w.rootDirectories = append(w.rootDirectories, name)
+		}
+	})
+	return nil
+}
+
+// RemoveDirectory removes a directory from the watched directories
+// watches on the directory and its contents are removed immediately
+func (w *FileWatcher) RemoveDirectory(name string) error {
+	name = filepath.Clean(name)
+	if !slices.Contains(w.roots(), name) {
+		return fmt.Errorf("'%s' is not a watched directory", name)
+	}
+	w.updateScope(func() {
+		w.rootDirectories = slices.DeleteFunc(w.rootDirectories, func(d string) bool { return d == name })
+	})
+	return nil
+}
+
+// SetFilters replaces the inclusions and exclusions (in .gitignore format)
+// watches are added and removed immediately to match the new filters
+func (w *FileWatcher) SetFilters(include, exclude []string) {
+	w.updateScope(func() {
+		w.includePatterns = include
+		w.excludePatterns = exclude
+	})
+}
+
+// updateScope applies an update to the root directories or filters, then updates the watched directories
+// and watches to match
+// if emitScopeChanges is set, events are raised for files which enter or leave scope
+// returns the files which were created since the last poll, for which create events have been raised
+func (w *FileWatcher) updateScope(update func()) []string {
+	created, events := w.applyScopeUpdate(update)
+	// raise the events once the scope lock is released - a new ignore file is passed to reloadIgnoreFile,
+	// which updates the scope again
+	w.scheduleCreateEvents(created)
+	if len(events) > 0 {
+		w.raiseEvents(JournalSourceScope, events)
+	}
+	return created
+}
+
+// applyScopeUpdate updates the scope, returning the files which were created since the last poll and the
+// events for any files which entered or left scope
+func (w *FileWatcher) applyScopeUpdate(update func()) (created []string, events []fsnotify.Event) {
+	// scope updates must not interleave
+	w.scopeLock.Lock()
+	defer w.scopeLock.Unlock()
+
+	started := w.started.Load()
+	emit := w.emitScopeChanges && started
+
+	if started {
+		// add watches for any files created since the last poll before the scope changes, so they are reported
+		// as created rather than treated as entering scope
+		created = w.addWatches()
+	}
+
+	var before map[string]bool
+	if emit {
+		before = w.filesInScope()
+	}
+
+	w.dirLock.Lock()
+	update()
+	w.resolveFilters()
+	// rebuild the watched directories, then swap them in, so the watched directories are never seen empty
+	directories := make(map[string]bool)
+	for _, root := range w.rootDirectories {
+		for _, d := range w.listDirectories(root) {
+			directories[d] = true
+		}
+	}
+	w.directories = directories
+	w.dirLock.Unlock()
+
+	w.removeStaleWatches()
+	if started {
+		// if we are not started, watches will be added when we start
+		w.addWatches()
+	}
+
+	if !emit {
+		return created, nil
+	}
+	after := w.filesInScope()
+	for p := range after {
+		if !before[p] {
+			events = append(events, fsnotify.Event{Name: p, Op: fsnotify.Create})
+		}
+	}
+	for p := range before {
+		if !after[p] {
+			events = append(events, fsnotify.Event{Name: p, Op: fsnotify.Remove})
+		}
+	}
+	return created, events
+}
+
+// removeStaleWatches removes the watches on paths which are no longer in scope
+func (w *FileWatcher) removeStaleWatches() {
+	w.dirLock.Lock()
+	defer w.dirLock.Unlock()
+
+	for p, watched := range w.watches {
+		if !watched {
+			continue
+		}
+		var inScope bool
+		if w.watchMode == WatchDirectories {
+			inScope = w.directories[p]
+		} else {
+			inScope = w.directories[filepath.Dir(p)] &&
+				((w.ignore != nil && isIgnoreFile(p)) || (files.ShouldIncludePath(p, w.include, w.exclude) && !w.isIgnored(p, false)))
+		}
+		if inScope {
+			continue
+		}
+		if err := w.watch.Remove(p); err != nil {
+			log.Printf("[TRACE] error occurred removing watch: %v", err)
+		}
+		delete(w.watches, p)
+	}
+}
+
+// filesInScope returns the set of files in the watched directories which meet our criteria
+func (w *FileWatcher) filesInScope() map[string]bool {
+	include, exclude := w.filters()
+	listFlag := files.FilesFlat
+	if w.recursive() {
+		listFlag = files.FilesRecursive
+	}
+	opts := &files.ListOptions{
+		Flags:   listFlag,
+		Include: include,
+		Exclude: exclude,
+	}
+	w.limitListing(opts, 0)
+
+	res := make(map[string]bool)
+	for _, d := range w.roots() {
+		paths, err := w.listFiles(d, opts)
+		if err != nil {
+			log.Printf("[TRACE] failed to list files in '%s': %v", d, err)
+			continue
+		}
+		for _, p := range paths {
+			res[p] = true
+		}
+	}
+	return res
+}
+
+// resolveFilters converts the inclusions and exclusions into absolute globs by joining with each of the directories
+// must be called with the dirLock held
+func (w *FileWatcher) resolveFilters() {
+	w.include = files.ResolveGlobRoots(w.includePatterns, w.rootDirectories...)
+	w.exclude = files.ResolveGlobRoots(w.excludePatterns, w.rootDirectories...)
+	w.resolveRoutes()
+}
+
+// filters returns the resolved inclusions and exclusions
+func (w *FileWatcher) filters() (include, exclude []string) {
+	w.dirLock.Lock()
+	defer w.dirLock.Unlock()
+	return w.include, w.exclude
+}
+
+// roots returns the root directories
+func (w *FileWatcher) roots() []string {
+	w.dirLock.Lock()
+	defer w.dirLock.Unlock()
+	return append([]string{}, w.rootDirectories...)
+}
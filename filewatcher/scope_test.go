@@ -0,0 +1,91 @@
+package filewatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateScope(t *testing.T) {
+	dir1 := t.TempDir()
+	dir2 := t.TempDir()
+	a := filepath.Join(dir1, "a.sp")
+	b := filepath.Join(dir2, "b.sp")
+	c := filepath.Join(dir2, "c.spc")
+	for _, p := range []string{a, b, c} {
+		assert.NoError(t, os.WriteFile(p, []byte(p), 0644))
+	}
+
+	changes := make(chan ChangeSet, 10)
+	w, err := NewWatcher(&WatcherOptions{
+		Directories:      []string{dir1},
+		Include:          []string{"*.sp"},
+		EmitScopeChanges: true,
+		OnChangeSet:      func(c ChangeSet) { changes <- c },
+	})
+	assert.NoError(t, err)
+	w.Start()
+	defer w.Close(context.Background())
+	assert.Equal(t, map[string]bool{a: true}, w.watches)
+
+	// adding a directory adds watches and raises create events for the files which are now in scope
+	assert.NoError(t, w.AddDirectory(dir2))
+	assert.Equal(t, map[string]bool{a: true, b: true}, w.watches)
+	assert.Equal(t, ChangeSet{{Path: b, Type: Added}}, receive(t, changes))
+
+	// changing the filters
+	w.SetFilters([]string{"*.spc"}, nil)
+	assert.Equal(t, map[string]bool{c: true}, w.watches)
+	assert.ElementsMatch(t, ChangeSet{{Path: a, Type: Deleted}, {Path: b, Type: Deleted}, {Path: c, Type: Added}}, receive(t, changes))
+
+	// removing a directory
+	assert.NoError(t, w.RemoveDirectory(dir2))
+	assert.Empty(t, w.watches)
+	assert.Equal(t, ChangeSet{{Path: c, Type: Deleted}}, receive(t, changes))
+	assert.Error(t, w.RemoveDirectory(dir2))
+}
+
+func TestConcurrentScopeUpdates(t *testing.T) {
+	dir1 := t.TempDir()
+	dir2 := t.TempDir()
+	w, err := NewWatcher(&WatcherOptions{Directories: []string{dir1}, OnChange: func([]fsnotify.Event) {}})
+	assert.NoError(t, err)
+	w.Start()
+	defer w.Close(context.Background())
+
+	var updates sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		updates.Add(1)
+		go func() {
+			defer updates.Done()
+			for j := 0; j < 50; j++ {
+				assert.NoError(t, w.AddDirectory(dir2))
+				w.SetFilters([]string{"*.sp"}, nil)
+				_ = w.RemoveDirectory(dir2)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		updates.Wait()
+		close(done)
+	}()
+
+	// the watched directories are swapped in when they have been rebuilt, so are never seen empty
+	for {
+		select {
+		case <-done:
+			assert.Equal(t, 1, w.Stats().Directories)
+			return
+		default:
+			if w.Stats().Directories == 0 {
+				t.Fatal("watched directories were empty during a scope update")
+			}
+		}
+	}
+}
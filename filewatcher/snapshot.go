@@ -0,0 +1,213 @@
+package filewatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/turbot/go-kit/files"
+	"github.com/turbot/go-kit/helpers"
+)
+
+const snapshotVersion = 1
+
+// SnapshotEntry is the state of a single path in a Snapshot
+type SnapshotEntry struct {
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mod_time"`
+	Mode    fs.FileMode `json:"mode"`
+	// the hash of the file content - only set if the snapshot was captured with hashes
+	Hash string `json:"hash,omitempty"`
+}
+
+func newSnapshotEntry(info fs.FileInfo) SnapshotEntry {
+	return SnapshotEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+	}
+}
+
+// contentChanged returns whether the content of the file has changed
+// if both entries have a hash, this is used, otherwise the size and modified time are compared
+func (e SnapshotEntry) contentChanged(other SnapshotEntry) bool {
+	if e.Hash != "" && other.Hash != "" {
+		return e.Hash != other.Hash
+	}
+	return e.Size != other.Size || !e.ModTime.Equal(other.ModTime)
+}
+
+// Snapshot is the state of a set of paths at a point in time
+// It can be saved to disk and compared with a later snapshot to determine what changed in between,
+// for example while a service was not running
+type Snapshot struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	// the state of each path, keyed by path
+	Entries map[string]SnapshotEntry `json:"entries"`
+}
+
+type SnapshotOptions struct {
+	// .gitignore (fnmatch) format patterns for file inclusions and exclusions
+	Include []string
+	Exclude []string
+	// if not set, files are listed recursively
+	ListFlag files.ListFlag
+	// the maximum depth to list files to (see files.ListOptions)
+	MaxDepth int
+	// whether to follow symlinks to directories (see files.ListOptions)
+	FollowSymlinks bool
+	// if set, the content hash of each file is stored, so files whose modified time changed but whose content
+	// did not are not reported as modified
+	Hash bool
+}
+
+// NewSnapshot builds a snapshot of the given paths, for example the results of files.ListFiles
+// paths which no longer exist are not included
+func NewSnapshot(paths []string, hash bool) (*Snapshot, error) {
+	s := &Snapshot{
+		Version: snapshotVersion,
+		Time:    time.Now(),
+		Entries: make(map[string]SnapshotEntry, len(paths)),
+	}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// the path may have been removed since it was listed
+				continue
+			}
+			return nil, err
+		}
+		entry := newSnapshotEntry(info)
+		if hash && info.Mode().IsRegular() {
+			entry.Hash, err = helpers.FileHash(p)
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.Entries[p] = entry
+	}
+	return s, nil
+}
+
+// CaptureSnapshot lists the files in the given directories and builds a snapshot of them
+func CaptureSnapshot(directories []string, opts *SnapshotOptions) (*Snapshot, error) {
+	if opts == nil {
+		opts = &SnapshotOptions{}
+	}
+	listFlag := opts.ListFlag
+	if listFlag == 0 {
+		listFlag = files.FilesRecursive
+	}
+	var paths []string
+	for _, d := range directories {
+		listOpts := &files.ListOptions{
+			Flags:          listFlag,
+			Include:        opts.Include,
+			Exclude:        opts.Exclude,
+			MaxDepth:       opts.MaxDepth,
+			FollowSymlinks: opts.FollowSymlinks,
+		}
+		dirPaths, err := files.ListFiles(d, listOpts)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, dirPaths...)
+	}
+	return NewSnapshot(paths, opts.Hash)
+}
+
+// LoadSnapshot loads a snapshot saved with Snapshot.Save
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot '%s': %v", path, err)
+	}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d in '%s'", s.Version, path)
+	}
+	if s.Entries == nil {
+		s.Entries = make(map[string]SnapshotEntry)
+	}
+	return s, nil
+}
+
+// Save writes the snapshot to the given path
+// the snapshot is written to a temporary file which is then renamed, so an existing snapshot is never left half written
+func (s *Snapshot) Save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Diff returns the changes from this snapshot to the current snapshot
+func (s *Snapshot) Diff(current *Snapshot) ChangeSet {
+	return NewChangeSet(s.events(current))
+}
+
+// events returns the events which would have been raised to get from this snapshot to the current snapshot
+func (s *Snapshot) events(current *Snapshot) []fsnotify.Event {
+	return diffSnapshotEntries(s.Entries, current.Entries)
+}
+
+// diffSnapshotEntries compares 2 sets of snapshot entries, keyed by path, and returns the events
+// which would have been raised to get from the previous state to the current state
+// events are sorted by path
+func diffSnapshotEntries(previous, current map[string]SnapshotEntry) []fsnotify.Event {
+	var events []fsnotify.Event
+	for path, prev := range previous {
+		curr, ok := current[path]
+		if !ok {
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Remove})
+			continue
+		}
+		// a change of type (e.g. file replaced by a directory) is a remove and a create
+		if prev.Mode.Type() != curr.Mode.Type() {
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Remove})
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+			continue
+		}
+		// fsnotify does not raise write events for directories
+		if !curr.Mode.IsDir() && prev.contentChanged(curr) {
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+		if prev.Mode.Perm() != curr.Mode.Perm() {
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Chmod})
+		}
+	}
+	for path := range current {
+		if _, ok := previous[path]; !ok {
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+		}
+	}
+	// sort by path - use a stable sort to preserve the order of events for the same path
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Name < events[j].Name
+	})
+	return events
+}
@@ -0,0 +1,74 @@
+package filewatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSnapshotEntries(t *testing.T) {
+	now := time.Now()
+	previous := map[string]SnapshotEntry{
+		"/a":   {Size: 1, ModTime: now, Mode: 0644},
+		"/b":   {Size: 1, ModTime: now, Mode: 0644},
+		"/c":   {Size: 1, ModTime: now, Mode: 0644},
+		"/dir": {Size: 64, ModTime: now, Mode: os.ModeDir | 0755},
+	}
+	current := map[string]SnapshotEntry{
+		"/a":   {Size: 2, ModTime: now.Add(time.Second), Mode: 0644},
+		"/c":   {Size: 1, ModTime: now, Mode: 0600},
+		"/d":   {Size: 1, ModTime: now, Mode: 0644},
+		"/dir": {Size: 96, ModTime: now.Add(time.Second), Mode: os.ModeDir | 0755},
+	}
+	expected := []fsnotify.Event{
+		{Name: "/a", Op: fsnotify.Write},
+		{Name: "/b", Op: fsnotify.Remove},
+		{Name: "/c", Op: fsnotify.Chmod},
+		{Name: "/d", Op: fsnotify.Create},
+	}
+	assert.Equal(t, expected, diffSnapshotEntries(previous, current))
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "unchanged.sp")
+	modified := filepath.Join(dir, "modified.sp")
+	touched := filepath.Join(dir, "touched.sp")
+	deleted := filepath.Join(dir, "deleted.sp")
+	added := filepath.Join(dir, "sub", "added.sp")
+	for _, p := range []string{unchanged, modified, touched, deleted} {
+		assert.NoError(t, os.WriteFile(p, []byte(p), 0644))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("a"), 0644))
+
+	opts := &SnapshotOptions{Include: []string{"**/*.sp"}, Hash: true}
+	previous, err := CaptureSnapshot([]string{dir}, opts)
+	assert.NoError(t, err)
+	assert.Len(t, previous.Entries, 4)
+
+	// save and reload the snapshot
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.json")
+	assert.NoError(t, previous.Save(snapshotPath))
+	previous, err = LoadSnapshot(snapshotPath)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(modified, []byte("modified"), 0644))
+	// the modified time of touched changes but the content does not
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(touched, later, later))
+	assert.NoError(t, os.Remove(deleted))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	assert.NoError(t, os.WriteFile(added, []byte("added"), 0644))
+
+	current, err := CaptureSnapshot([]string{dir}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, ChangeSet{
+		{Path: deleted, Type: Deleted},
+		{Path: modified, Type: Modified},
+		{Path: added, Type: Added},
+	}, previous.Diff(current))
+}
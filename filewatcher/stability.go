@@ -0,0 +1,224 @@
+package filewatcher
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	defaultStabilityPeriod  = time.Second
+	defaultStabilityMaxWait = time.Minute
+)
+
+// opStable is set on the events released once their file is stable, so the flag is carried with the events to the
+// ChangeSet - it is cleared before the events are passed to handlers or subscribers (see clearStable)
+const opStable fsnotify.Op = 1 << 31
+
+// WriteStability holds the Create and Write events for a file until it has stopped changing
+// (e.g. while a large file is copied into a watched directory)
+type WriteStability struct {
+	// the period for which the size and modified time of a file must be unchanged
+	// if not set, a default of 1s is used
+	Period time.Duration
+	// the maximum time to hold the events for a file - once this has elapsed the events are published,
+	// and the change is reported with Stable unset
+	// if not set, a default of 1 minute is used
+	MaxWait time.Duration
+}
+
+// stabilityTracker holds the events for each path until its size and modified time are unchanged for the period
+type stabilityTracker struct {
+	period  time.Duration
+	maxWait time.Duration
+	clock   Clock
+	// called with the held events for a path once it is stable (or the wait has been abandoned)
+	release func(events []fsnotify.Event)
+
+	lock sync.Mutex
+	// the held events, keyed by path
+	held   map[string]*heldPath
+	closed bool
+	// tracks scheduled and running checks, so close can wait for them
+	waitGroup sync.WaitGroup
+}
+
+type heldPath struct {
+	events     []fsnotify.Event
+	firstEvent time.Time
+	// the size and modified time when the path was last checked
+	size    int64
+	modTime time.Time
+	timer   Timer
+}
+
+func newStabilityTracker(opts *WriteStability, clock Clock, release func([]fsnotify.Event)) *stabilityTracker {
+	t := &stabilityTracker{
+		period:  opts.Period,
+		maxWait: opts.MaxWait,
+		clock:   clock,
+		release: release,
+		held:    make(map[string]*heldPath),
+	}
+	if t.period <= 0 {
+		t.period = defaultStabilityPeriod
+	}
+	if t.maxWait <= 0 {
+		t.maxWait = defaultStabilityMaxWait
+	}
+	return t
+}
+
+// add returns whether the event has been held (or released with the held events for the path)
+// Create and Write events are held; any other event for a path with held events releases them, followed by the event
+func (t *stabilityTracker) add(ev fsnotify.Event) bool {
+	t.lock.Lock()
+	if t.closed {
+		t.lock.Unlock()
+		return false
+	}
+	held, isHeld := t.held[ev.Name]
+
+	if ev.Has(fsnotify.Create) || ev.Has(fsnotify.Write) {
+		if !isHeld {
+			held = &heldPath{firstEvent: t.clock.Now()}
+			if info, err := os.Stat(ev.Name); err == nil {
+				held.size, held.modTime = info.Size(), info.ModTime()
+			}
+			t.held[ev.Name] = held
+			t.scheduleCheck(ev.Name, held)
+		}
+		held.events = append(held.events, ev)
+		t.lock.Unlock()
+		return true
+	}
+
+	if !isHeld {
+		t.lock.Unlock()
+		return false
+	}
+	if held.timer.Stop() {
+		t.waitGroup.Done()
+	}
+	delete(t.held, ev.Name)
+	t.lock.Unlock()
+
+	t.release(append(held.events, ev))
+	return true
+}
+
+// scheduleCheck checks the path once the period has elapsed
+// must be called with the lock held
+func (t *stabilityTracker) scheduleCheck(path string, held *heldPath) {
+	t.waitGroup.Add(1)
+	held.timer = t.clock.AfterFunc(t.period, func() { t.check(path, held) })
+}
+
+// check releases the held events for the path if it has not changed since the last check
+// if the path is stable, the released events are flagged with opStable
+func (t *stabilityTracker) check(path string, held *heldPath) {
+	defer t.waitGroup.Done()
+
+	info, err := os.Stat(path)
+
+	t.lock.Lock()
+	if t.held[path] != held {
+		// the events have already been released
+		t.lock.Unlock()
+		return
+	}
+	var stable bool
+	switch {
+	case err != nil:
+		// the path has been removed - the remove event will follow
+	case info.Size() == held.size && info.ModTime().Equal(held.modTime):
+		stable = true
+	case t.clock.Now().Sub(held.firstEvent) < t.maxWait:
+		// still changing - check again after the period
+		held.size, held.modTime = info.Size(), info.ModTime()
+		t.scheduleCheck(path, held)
+		t.lock.Unlock()
+		return
+	}
+	delete(t.held, path)
+	t.lock.Unlock()
+
+	if stable {
+		for i := range held.events {
+			held.events[i].Op |= opStable
+		}
+	}
+	t.release(held.events)
+}
+
+// close stops holding events - held events are either discarded or released, according to the policy
+// (the released changes are reported with Stable unset)
+// close waits for any running check, so events released by a check are not lost when the batchers are closed
+func (t *stabilityTracker) close(policy PendingEventPolicy) {
+	t.lock.Lock()
+	t.closed = true
+	var events []fsnotify.Event
+	for path, held := range t.held {
+		if held.timer.Stop() {
+			t.waitGroup.Done()
+		}
+		events = append(events, held.events...)
+		delete(t.held, path)
+	}
+	t.lock.Unlock()
+
+	if policy == DrainPendingEvents && len(events) > 0 {
+		t.release(events)
+	}
+	t.waitGroup.Wait()
+}
+
+// queueStableEvent passes an event which has passed our filters to the handler scheduler,
+// holding Create and Write events until the file is stable if WriteStability is set
+func (w *FileWatcher) queueStableEvent(ev fsnotify.Event) {
+	if w.stability == nil || !w.stability.add(ev) {
+		w.queueEvent(ev)
+	}
+}
+
+// releaseStableEvents passes events released by the stability tracker to the handler scheduler
+func (w *FileWatcher) releaseStableEvents(events []fsnotify.Event) {
+	for _, ev := range events {
+		w.queueEvent(ev)
+	}
+}
+
+// changeSet returns the ChangeSet for the events, with the Stable flag set if WriteStability is set
+// and the last event for the path was released because the file was stable
+func (w *FileWatcher) changeSet(events []fsnotify.Event) ChangeSet {
+	changes := NewChangeSet(clearStable(events))
+	if w.stability != nil {
+		stable := make(map[string]bool)
+		for _, ev := range events {
+			stable[ev.Name] = ev.Has(opStable)
+		}
+		for i, c := range changes {
+			if c.Type != Deleted {
+				changes[i].Stable = stable[c.Path]
+			}
+		}
+	}
+	return changes
+}
+
+// clearStable returns the events without the opStable flag
+// the events are only copied if any are flagged
+func clearStable(events []fsnotify.Event) []fsnotify.Event {
+	for i, ev := range events {
+		if ev.Has(opStable) {
+			res := append([]fsnotify.Event{}, events...)
+			for j := i; j < len(res); j++ {
+				res[j].Op &^= opStable
+			}
+			return res
+		}
+	}
+	return events
+}
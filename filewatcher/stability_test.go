@@ -0,0 +1,67 @@
+package filewatcher_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/go-kit/filewatcher"
+)
+
+func TestWriteStability(t *testing.T) {
+	tests := map[string]struct {
+		// the number of stability periods for which the file is written to
+		writePeriods int
+		wantStable   bool
+	}{
+		"stable": {
+			writePeriods: 2,
+			wantStable:   true,
+		},
+		"max wait elapsed": {
+			writePeriods: 4,
+			wantStable:   false,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "data.csv")
+			w := newFakeWatcher(t, filewatcher.WatcherOptions{
+				Directories:    []string{dir},
+				Include:        []string{"*.csv"},
+				WatchMode:      filewatcher.WatchDirectories,
+				WriteStability: &filewatcher.WriteStability{Period: time.Second, MaxWait: 4 * time.Second},
+			})
+
+			f, err := os.Create(path)
+			assert.NoError(t, err)
+			defer f.Close()
+			w.backend.Send(fsnotify.Event{Name: path, Op: fsnotify.Create})
+
+			// keep writing to the file - no changes are published until it is stable, or the max wait has elapsed
+			for i := 0; i < test.writePeriods; i++ {
+				_, err := f.WriteString("some data\n")
+				assert.NoError(t, err)
+				w.backend.Send(fsnotify.Event{Name: path, Op: fsnotify.Write})
+				w.clock.Advance(time.Second)
+				if test.wantStable {
+					assert.Empty(t, w.changes, "change published while file was still being written")
+				}
+			}
+			// wait for the next check, and the debounce
+			w.clock.Advance(time.Second + 100*time.Millisecond)
+			assert.Equal(t, []filewatcher.ChangeSet{{{Path: path, Type: filewatcher.Added, Stable: test.wantStable}}}, w.changes)
+			// the raw events do not carry the stable flag
+			assert.Equal(t, fsnotify.Create, w.batches[0][0].Op)
+
+			// a later change which is not held is not reported as stable
+			w.backend.Send(fsnotify.Event{Name: path, Op: fsnotify.Chmod})
+			w.clock.Advance(100 * time.Millisecond)
+			assert.Equal(t, filewatcher.ChangeSet{{Path: path, Type: filewatcher.Modified}}, w.changes[1])
+		})
+	}
+}
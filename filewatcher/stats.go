@@ -0,0 +1,191 @@
+package filewatcher
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultStatsInterval = time.Minute
+
+// the upper bounds of the handler latency histogram buckets
+var handlerLatencyBounds = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// Stats is a point in time view of the state and activity of a FileWatcher
+type Stats struct {
+	// the number of paths with a backend watch
+	Watches int
+	// the number of directories being watched (including child directories when watching recursively)
+	Directories int
+
+	// the number of events received from the backend
+	EventsReceived uint64
+	// the number of events dropped as they did not meet the inclusions/exclusions
+	EventsFiltered uint64
+	// the number of events dropped as they did not match the event mask
+	EventsMasked uint64
+	// the number of events dropped as they were for paths being written by the application (see Suppress and BeginWrite)
+	EventsSuppressed uint64
+	// the number of events passed to the handlers
+	EventsDelivered uint64
+	// the number of handler runs
+	Batches uint64
+
+	// the number of polls for new files, and the duration of the last and slowest poll
+	Polls            uint64
+	LastPollDuration time.Duration
+	MaxPollDuration  time.Duration
+
+	// the time taken by each handler run
+	HandlerLatency LatencyHistogram
+
+	// the last error reported, and when it occurred
+	LastError     error
+	LastErrorTime time.Time
+}
+
+// LatencyHistogram counts durations in buckets
+type LatencyHistogram struct {
+	// the upper bound of each bucket
+	Bounds []time.Duration
+	// Counts[i] is the number of durations greater than Bounds[i-1] and less than or equal to Bounds[i]
+	// there is one more count than bounds - the final count is the number of durations greater than the last bound
+	Counts []uint64
+	// the total number, sum and maximum of the durations
+	Count uint64
+	Sum   time.Duration
+	Max   time.Duration
+}
+
+func newLatencyHistogram(bounds []time.Duration) LatencyHistogram {
+	return LatencyHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *LatencyHistogram) record(d time.Duration) {
+	i := 0
+	for i < len(h.Bounds) && d > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Mean returns the mean duration
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+func (h LatencyHistogram) clone() LatencyHistogram {
+	h.Bounds = append([]time.Duration{}, h.Bounds...)
+	h.Counts = append([]uint64{}, h.Counts...)
+	return h
+}
+
+// watcherStats accumulates the activity counters of a FileWatcher
+type watcherStats struct {
+	lock  sync.Mutex
+	stats Stats
+}
+
+func newWatcherStats() *watcherStats {
+	return &watcherStats{
+		stats: Stats{HandlerLatency: newLatencyHistogram(handlerLatencyBounds)},
+	}
+}
+
+func (s *watcherStats) update(f func(stats *Stats)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f(&s.stats)
+}
+
+func (s *watcherStats) eventReceived() {
+	s.update(func(stats *Stats) { stats.EventsReceived++ })
+}
+
+func (s *watcherStats) eventFiltered() {
+	s.update(func(stats *Stats) { stats.EventsFiltered++ })
+}
+
+func (s *watcherStats) eventMasked() {
+	s.update(func(stats *Stats) { stats.EventsMasked++ })
+}
+
+func (s *watcherStats) eventSuppressed() {
+	s.update(func(stats *Stats) { stats.EventsSuppressed++ })
+}
+
+func (s *watcherStats) batchDelivered(events int, latency time.Duration) {
+	s.update(func(stats *Stats) {
+		stats.EventsDelivered += uint64(events)
+		stats.Batches++
+		stats.HandlerLatency.record(latency)
+	})
+}
+
+func (s *watcherStats) polled(duration time.Duration) {
+	s.update(func(stats *Stats) {
+		stats.Polls++
+		stats.LastPollDuration = duration
+		if duration > stats.MaxPollDuration {
+			stats.MaxPollDuration = duration
+		}
+	})
+}
+
+func (s *watcherStats) errorOccurred(err error, at time.Time) {
+	s.update(func(stats *Stats) {
+		stats.LastError = err
+		stats.LastErrorTime = at
+	})
+}
+
+func (s *watcherStats) get() Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := s.stats
+	res.HandlerLatency = s.stats.HandlerLatency.clone()
+	return res
+}
+
+// Stats returns the current statistics of the watcher
+func (w *FileWatcher) Stats() Stats {
+	stats := w.stats.get()
+
+	w.dirLock.Lock()
+	defer w.dirLock.Unlock()
+	for _, watched := range w.watches {
+		if watched {
+			stats.Watches++
+		}
+	}
+	stats.Directories = len(w.directories)
+	return stats
+}
+
+// reportStats calls the stats reporter every stats interval, until the watcher is closed or ctx is done
+func (w *FileWatcher) reportStats(done <-chan struct{}) {
+	for {
+		select {
+		case <-w.clock.After(w.statsInterval):
+			w.statsReporter(w.Stats())
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package filewatcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := newLatencyHistogram([]time.Duration{time.Millisecond, time.Second})
+	h.record(time.Microsecond)
+	h.record(time.Millisecond)
+	h.record(500 * time.Millisecond)
+	h.record(time.Minute)
+
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(4), h.Count)
+	assert.Equal(t, time.Minute, h.Max)
+	assert.Equal(t, (time.Microsecond+time.Millisecond+500*time.Millisecond+time.Minute)/4, h.Mean())
+}
+
+func TestStats(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.sp")
+	assert.NoError(t, os.WriteFile(a, []byte("a"), 0644))
+
+	reports := make(chan Stats, 10)
+	w, err := NewWatcher(&WatcherOptions{
+		Directories:   []string{dir},
+		Include:       []string{"*.sp"},
+		EventMask:     fsnotify.Write,
+		OnChange:      func([]fsnotify.Event) {},
+		StatsReporter: func(s Stats) { reports <- s },
+		StatsInterval: 10 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	w.Start()
+	defer w.Close(context.Background())
+
+	w.handleFileEvent(fsnotify.Event{Name: filepath.Join(dir, "b.txt"), Op: fsnotify.Write})
+	w.handleFileEvent(fsnotify.Event{Name: a, Op: fsnotify.Chmod})
+	w.handleBatch(context.Background(), []fsnotify.Event{{Name: a, Op: fsnotify.Write}}, nil)
+	w.reportError(errors.New("failed"))
+
+	stats := w.Stats()
+	assert.Equal(t, 1, stats.Watches)
+	assert.Equal(t, 1, stats.Directories)
+	assert.Equal(t, uint64(1), stats.EventsFiltered)
+	assert.Equal(t, uint64(1), stats.EventsMasked)
+	assert.Equal(t, uint64(1), stats.EventsDelivered)
+	assert.Equal(t, uint64(1), stats.Batches)
+	assert.Equal(t, uint64(1), stats.HandlerLatency.Count)
+	assert.EqualError(t, stats.LastError, "failed")
+
+	receive(t, reports)
+}
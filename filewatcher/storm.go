@@ -0,0 +1,230 @@
+package filewatcher
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const defaultStormWindow = time.Second
+
+// StormDetection collapses bursts of events (e.g. from a branch switch or a package install) into a single BulkChange
+type StormDetection struct {
+	// a storm starts when more than Threshold events arrive within Window
+	Threshold int
+	// the window in which events are counted - a storm ends once no events have arrived for this period
+	// if not set, a default of 1s is used
+	Window time.Duration
+}
+
+// BulkChange is passed to OnBulkChange when a storm of events ends, and published to the subscribers whose
+// patterns match any of the changed paths (see ChangeBatch.Bulk)
+// the individual events of the storm are not passed to the handlers or subscribers
+type BulkChange struct {
+	// the watched root directories which contain the changed paths
+	Roots []string
+	// the number of events in the storm
+	Events int
+}
+
+// stormDetector counts events, and absorbs the events of a storm until it ends
+type stormDetector struct {
+	threshold int
+	window    time.Duration
+	clock     Clock
+	// called with the paths and number of events of a storm once it has ended
+	onEnd func(paths []string, events int)
+
+	lock sync.Mutex
+	// the start of the current counting window and the number of events in it
+	windowStart time.Time
+	count       int
+	// set while a storm is in progress
+	storming bool
+	paths    map[string]struct{}
+	events   int
+	// fires when the storm has been quiet for the window
+	timer           Timer
+	timerGeneration int
+	closed          bool
+	// tracks scheduled and running onEnd calls, so close can wait for them
+	waitGroup sync.WaitGroup
+}
+
+func newStormDetector(opts *StormDetection, clock Clock, onEnd func(paths []string, events int)) *stormDetector {
+	window := opts.Window
+	if window <= 0 {
+		window = defaultStormWindow
+	}
+	return &stormDetector{
+		threshold: opts.Threshold,
+		window:    window,
+		clock:     clock,
+		onEnd:     onEnd,
+	}
+}
+
+// add counts the events and returns whether they are part of a storm, in which case they have been absorbed
+// started is set if these events started the storm
+func (d *stormDetector) add(events []fsnotify.Event) (absorbed, started bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed || len(events) == 0 {
+		return false, false
+	}
+	now := d.clock.Now()
+	if !d.storming {
+		if d.windowStart.IsZero() || now.Sub(d.windowStart) > d.window {
+			d.windowStart = now
+			d.count = 0
+		}
+		d.count += len(events)
+		if d.count <= d.threshold {
+			return false, false
+		}
+		// this is a storm
+		d.storming = true
+		d.paths = make(map[string]struct{})
+		d.events = 0
+		started = true
+	}
+	d.absorbLocked(events)
+	// the storm ends once there have been no events for the window
+	d.resetTimer(d.window)
+	return true, started
+}
+
+// absorb adds events to the current storm (used for the events waiting for a handler run when the storm starts)
+func (d *stormDetector) absorb(events []fsnotify.Event) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.storming {
+		d.absorbLocked(events)
+	}
+}
+
+// must be called with the lock held
+func (d *stormDetector) absorbLocked(events []fsnotify.Event) {
+	for _, ev := range events {
+		d.paths[ev.Name] = struct{}{}
+	}
+	d.events += len(events)
+}
+
+// must be called with the lock held
+func (d *stormDetector) resetTimer(delay time.Duration) {
+	if d.timer != nil && d.timer.Stop() {
+		d.waitGroup.Done()
+	}
+	d.timerGeneration++
+	generation := d.timerGeneration
+	d.waitGroup.Add(1)
+	d.timer = d.clock.AfterFunc(delay, func() { d.end(generation) })
+}
+
+// end ends the storm and calls onEnd
+func (d *stormDetector) end(generation int) {
+	defer d.waitGroup.Done()
+
+	d.lock.Lock()
+	if generation != d.timerGeneration || !d.storming {
+		d.lock.Unlock()
+		return
+	}
+	paths := make([]string, 0, len(d.paths))
+	for p := range d.paths {
+		paths = append(paths, p)
+	}
+	events := d.events
+	d.storming = false
+	d.paths = nil
+	d.events = 0
+	d.windowStart = time.Time{}
+	d.timer = nil
+	d.lock.Unlock()
+
+	d.onEnd(paths, events)
+}
+
+// close stops the detector absorbing events
+// a storm in progress is either discarded or ended immediately, according to the policy
+func (d *stormDetector) close(policy PendingEventPolicy) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.closed = true
+	if !d.storming {
+		return
+	}
+	if policy == DiscardPendingEvents {
+		d.storming = false
+		if d.timer != nil && d.timer.Stop() {
+			d.waitGroup.Done()
+		}
+		d.timer = nil
+		return
+	}
+	d.resetTimer(0)
+}
+
+// wait returns a channel which is closed when any scheduled or running onEnd call is complete
+func (d *stormDetector) wait() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		d.waitGroup.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// handleStorm passes the events to the storm detector (if enabled) and returns whether they were absorbed by a storm
+func (w *FileWatcher) handleStorm(events []fsnotify.Event) bool {
+	if w.storm == nil {
+		return false
+	}
+	absorbed, started := w.storm.add(events)
+	if started {
+		// the events waiting for a handler run are part of the storm
+		// (the events waiting for route handlers are also waiting for the main handlers, so are only counted once)
+		w.storm.absorb(w.batcher.discardPending())
+		for _, r := range w.routes {
+			r.batcher.discardPending()
+		}
+	}
+	return absorbed
+}
+
+// handleBulkChange passes the bulk change for the paths of a storm to OnBulkChange and the subscribers
+// OnBulkChange is called from the run loop of the main handlers, so it does not overlap them
+func (w *FileWatcher) handleBulkChange(paths []string, events int) {
+	roots := make(map[string]struct{})
+	for _, root := range w.roots() {
+		prefix := root + string(os.PathSeparator)
+		for _, p := range paths {
+			if p == root || strings.HasPrefix(p, prefix) {
+				roots[root] = struct{}{}
+				break
+			}
+		}
+	}
+	bulkChange := BulkChange{Events: events}
+	for root := range roots {
+		bulkChange.Roots = append(bulkChange.Roots, root)
+	}
+	sort.Strings(bulkChange.Roots)
+
+	w.batcher.runExclusive(func() {
+		start := w.clock.Now()
+		defer func() {
+			w.stats.batchDelivered(events, w.clock.Now().Sub(start))
+		}()
+		w.callHandler(context.Background(), func(context.Context) { w.onBulkChange(bulkChange) })
+		w.publishBulkToSubscribers(paths, bulkChange)
+	})
+}
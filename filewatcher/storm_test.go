@@ -0,0 +1,85 @@
+package filewatcher_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/go-kit/filewatcher"
+	"github.com/turbot/go-kit/filewatcher/filewatchertest"
+)
+
+func TestStormDetection(t *testing.T) {
+	dir := t.TempDir()
+	var bulkChanges []filewatcher.BulkChange
+	w := newFakeWatcher(t, filewatcher.WatcherOptions{
+		Directories:    []string{dir},
+		WatchMode:      filewatcher.WatchDirectories,
+		StormDetection: &filewatcher.StormDetection{Threshold: 5, Window: time.Second},
+		OnBulkChange:   func(b filewatcher.BulkChange) { bulkChanges = append(bulkChanges, b) },
+	})
+	sp, unsubscribeSp := w.Subscribe(&filewatcher.SubscribeOptions{Include: []string{"**/*.sp"}})
+	defer unsubscribeSp()
+	txt, unsubscribeTxt := w.Subscribe(&filewatcher.SubscribeOptions{Include: []string{"**/*.txt"}})
+	defer unsubscribeTxt()
+
+	event := func(name string) fsnotify.Event {
+		return fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Write}
+	}
+
+	// events below the threshold are handled as normal
+	w.backend.Send(event("a.sp"), event("b.sp"))
+	w.clock.Advance(100 * time.Millisecond)
+	assert.Len(t, w.batches, 1)
+	assert.Len(t, (<-sp).Events, 2)
+
+	// wait for the counting window to close, then raise a storm
+	w.clock.Advance(time.Second)
+	w.backend.Send(event("c.sp"), event("d.sp"), event("e.sp"))
+	for i := 0; i < 10; i++ {
+		w.backend.Send(event("f.sp"))
+	}
+	w.clock.Advance(time.Second)
+	bulk := filewatcher.BulkChange{Roots: []string{dir}, Events: 13}
+	assert.Equal(t, []filewatcher.BulkChange{bulk}, bulkChanges)
+	// the events of the storm are not passed to the handler
+	assert.Len(t, w.batches, 1)
+
+	// the subscribers interested in the changed paths are sent the bulk change
+	assert.Equal(t, filewatcher.ChangeBatch{Bulk: &bulk}, <-sp)
+	assert.Empty(t, txt)
+}
+
+func TestRunExclusive(t *testing.T) {
+	clock := filewatchertest.NewFakeClock(time.Time{})
+	// the handler is called by FakeClock.Advance, so does not need to be synchronised
+	var batches [][]fsnotify.Event
+	b := filewatcher.NewBatcher(nil, filewatcher.QueueEvents, clock, func(_ context.Context, events, _ []fsnotify.Event) {
+		batches = append(batches, events)
+	})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.RunExclusive(func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	// a batch which becomes due while the exclusive function is running is handled once it returns
+	ev := fsnotify.Event{Name: "a.sp", Op: fsnotify.Write}
+	b.Add(ev)
+	clock.Advance(100 * time.Millisecond)
+	assert.Empty(t, batches)
+
+	close(release)
+	<-done
+	assert.Equal(t, [][]fsnotify.Event{{ev}}, batches)
+}
@@ -0,0 +1,265 @@
+package filewatcher
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/turbot/go-kit/files"
+	"github.com/turbot/go-kit/helpers"
+)
+
+const defaultSubscriberBufferSize = 16
+
+// SlowConsumerPolicy determines what happens when a batch is published to a subscriber whose channel is full
+type SlowConsumerPolicy int
+
+const (
+	// BlockSlowConsumer waits for the subscriber to receive the batch
+	// note: this delays delivery to all handlers and subscribers of the watcher
+	BlockSlowConsumer SlowConsumerPolicy = iota
+	// DropOldest discards the oldest batch in the channel to make room for the new batch
+	DropOldest
+	// Coalesce merges all batches in the channel with the new batch, so no events are lost
+	Coalesce
+)
+
+// ChangeBatch is a batch of events published to a subscriber
+type ChangeBatch struct {
+	// the raw events
+	Events []fsnotify.Event
+	// the net change to each path
+	Changes ChangeSet
+	// set if a storm of events has ended, which changed paths matching the subscriber patterns (see StormDetection)
+	// the events of the storm are not published, so the subscriber should reload the state of the Bulk.Roots
+	Bulk *BulkChange
+}
+
+// merge returns a batch containing the events of both batches
+func (b ChangeBatch) merge(other ChangeBatch, changeSet func([]fsnotify.Event) ChangeSet) ChangeBatch {
+	events := append(append([]fsnotify.Event{}, b.Events...), other.Events...)
+	changes := changeSet(events)
+	// the published events do not carry the stable flag, so take it from the later change to each path
+	stable := make(map[string]bool)
+	for _, c := range append(append(ChangeSet{}, b.Changes...), other.Changes...) {
+		stable[c.Path] = c.Stable
+	}
+	for i, c := range changes {
+		if c.Type != Deleted {
+			changes[i].Stable = stable[c.Path]
+		}
+	}
+	return ChangeBatch{
+		Events:  events,
+		Changes: changes,
+		Bulk:    mergeBulkChanges(b.Bulk, other.Bulk),
+	}
+}
+
+// mergeBulkChanges returns a bulk change for the roots and events of both bulk changes (either may be nil)
+func mergeBulkChanges(a, b *BulkChange) *BulkChange {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	roots := helpers.AppendSliceUnique(a.Roots, b.Roots)
+	sort.Strings(roots)
+	return &BulkChange{
+		Roots:  roots,
+		Events: a.Events + b.Events,
+	}
+}
+
+type SubscribeOptions struct {
+	// .gitignore (fnmatch) format patterns for the paths this subscriber is interested in
+	// relative patterns are resolved against each of the watched directories
+	Include []string
+	Exclude []string
+	// the size of the channel buffer
+	// if not set, a default of 16 is used
+	BufferSize int
+	// what to do when the channel is full
+	SlowConsumerPolicy SlowConsumerPolicy
+}
+
+type subscriber struct {
+	include []string
+	exclude []string
+	policy  SlowConsumerPolicy
+	// builds the ChangeSet for a batch
+	changeSet func([]fsnotify.Event) ChangeSet
+
+	channel chan ChangeBatch
+	// closed when the subscriber unsubscribes, to unblock any pending send
+	done chan struct{}
+	// held while sending, so the channel is not closed during a send
+	sendLock  sync.Mutex
+	closeOnce sync.Once
+}
+
+// Subscribe returns a channel on which batches of events matching the options are published,
+// and a function to unsubscribe, which closes the channel
+//
+// All subscribers share the watches of the FileWatcher, so subscribing is much cheaper than creating another watcher.
+// The channel is also closed when the watcher is closed - if the watcher has already been closed,
+// the returned channel is closed.
+func (w *FileWatcher) Subscribe(opts *SubscribeOptions) (<-chan ChangeBatch, func()) {
+	if opts == nil {
+		opts = &SubscribeOptions{}
+	}
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultSubscriberBufferSize
+	}
+	roots := w.roots()
+	sub := &subscriber{
+		include:   files.ResolveGlobRoots(opts.Include, roots...),
+		exclude:   files.ResolveGlobRoots(opts.Exclude, roots...),
+		policy:    opts.SlowConsumerPolicy,
+		changeSet: w.changeSet,
+		channel:   make(chan ChangeBatch, bufferSize),
+		done:      make(chan struct{}),
+	}
+
+	w.subscriberLock.Lock()
+	if w.subscribersClosed {
+		w.subscriberLock.Unlock()
+		sub.close()
+		return sub.channel, func() {}
+	}
+	w.subscribers[sub] = struct{}{}
+	w.subscriberLock.Unlock()
+
+	unsubscribe := func() {
+		w.subscriberLock.Lock()
+		delete(w.subscribers, sub)
+		w.subscriberLock.Unlock()
+		sub.close()
+	}
+	return sub.channel, unsubscribe
+}
+
+// publish sends the events which match the subscriber filter
+func (s *subscriber) publish(events []fsnotify.Event) {
+	var matching []fsnotify.Event
+	for _, ev := range events {
+		if files.ShouldIncludePath(ev.Name, s.include, s.exclude) {
+			matching = append(matching, ev)
+		}
+	}
+	if len(matching) == 0 {
+		return
+	}
+	s.send(ChangeBatch{
+		Events:  clearStable(matching),
+		Changes: s.changeSet(matching),
+	})
+}
+
+// publishBulk sends the bulk change if any of the changed paths match the subscriber filter
+func (s *subscriber) publishBulk(paths []string, bulk BulkChange) {
+	for _, p := range paths {
+		if files.ShouldIncludePath(p, s.include, s.exclude) {
+			s.send(ChangeBatch{Bulk: &bulk})
+			return
+		}
+	}
+}
+
+// send sends the batch, according to the slow consumer policy
+func (s *subscriber) send(batch ChangeBatch) {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	// if we have unsubscribed, do not send
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case DropOldest:
+		for {
+			select {
+			case s.channel <- batch:
+				return
+			default:
+				// the channel is full - discard the oldest batch (unless the consumer has just received it)
+				select {
+				case <-s.channel:
+				default:
+				}
+			}
+		}
+	case Coalesce:
+		for {
+			select {
+			case s.channel <- batch:
+				return
+			default:
+				// the channel is full - merge all the buffered batches into this batch
+				for drained := false; !drained; {
+					select {
+					case pending := <-s.channel:
+						batch = pending.merge(batch, s.changeSet)
+					default:
+						drained = true
+					}
+				}
+			}
+		}
+	default:
+		select {
+		case s.channel <- batch:
+		case <-s.done:
+		}
+	}
+}
+
+func (s *subscriber) close() {
+	s.closeOnce.Do(func() {
+		// unblock any pending send before waiting for the send lock
+		close(s.done)
+		s.sendLock.Lock()
+		defer s.sendLock.Unlock()
+		close(s.channel)
+	})
+}
+
+// publishToSubscribers sends the events to all subscribers
+func (w *FileWatcher) publishToSubscribers(events []fsnotify.Event) {
+	for _, sub := range w.currentSubscribers() {
+		sub.publish(events)
+	}
+}
+
+// publishBulkToSubscribers sends the bulk change to the subscribers which are interested in any of the paths
+func (w *FileWatcher) publishBulkToSubscribers(paths []string, bulk BulkChange) {
+	for _, sub := range w.currentSubscribers() {
+		sub.publishBulk(paths, bulk)
+	}
+}
+
+func (w *FileWatcher) currentSubscribers() []*subscriber {
+	w.subscriberLock.Lock()
+	defer w.subscriberLock.Unlock()
+	subscribers := make([]*subscriber, 0, len(w.subscribers))
+	for sub := range w.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	return subscribers
+}
+
+// closeSubscribers unsubscribes all subscribers, closing their channels
+func (w *FileWatcher) closeSubscribers() {
+	w.subscriberLock.Lock()
+	defer w.subscriberLock.Unlock()
+	w.subscribersClosed = true
+	for sub := range w.subscribers {
+		sub.close()
+		delete(w.subscribers, sub)
+	}
+}
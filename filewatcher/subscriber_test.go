@@ -0,0 +1,95 @@
+package filewatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeFilter(t *testing.T) {
+	w := newTestWatcher(t, &WatcherOptions{Directories: []string{"/root"}})
+	sp, unsubscribeSp := w.Subscribe(&SubscribeOptions{Include: []string{"**/*.sp"}})
+	defer unsubscribeSp()
+	spc, unsubscribeSpc := w.Subscribe(&SubscribeOptions{Include: []string{"**/*.spc"}})
+	defer unsubscribeSpc()
+
+	w.handleBatch(context.Background(), []fsnotify.Event{
+		{Name: "/root/a.sp", Op: fsnotify.Write},
+		{Name: "/root/config/b.spc", Op: fsnotify.Create},
+	}, nil)
+
+	assert.Equal(t, ChangeBatch{
+		Events:  []fsnotify.Event{{Name: "/root/a.sp", Op: fsnotify.Write}},
+		Changes: ChangeSet{{Path: "/root/a.sp", Type: Modified}},
+	}, <-sp)
+	assert.Equal(t, ChangeBatch{
+		Events:  []fsnotify.Event{{Name: "/root/config/b.spc", Op: fsnotify.Create}},
+		Changes: ChangeSet{{Path: "/root/config/b.spc", Type: Added}},
+	}, <-spc)
+}
+
+func TestSubscribeDropOldest(t *testing.T) {
+	w := newTestWatcher(t, &WatcherOptions{Directories: []string{"/root"}})
+	ch, unsubscribe := w.Subscribe(&SubscribeOptions{BufferSize: 1, SlowConsumerPolicy: DropOldest})
+	defer unsubscribe()
+
+	w.handleBatch(context.Background(), []fsnotify.Event{{Name: "/root/a", Op: fsnotify.Write}}, nil)
+	w.handleBatch(context.Background(), []fsnotify.Event{{Name: "/root/b", Op: fsnotify.Write}}, nil)
+
+	batch := <-ch
+	assert.Equal(t, []fsnotify.Event{{Name: "/root/b", Op: fsnotify.Write}}, batch.Events)
+}
+
+func TestSubscribeCoalesce(t *testing.T) {
+	w := newTestWatcher(t, &WatcherOptions{Directories: []string{"/root"}})
+	ch, unsubscribe := w.Subscribe(&SubscribeOptions{BufferSize: 1, SlowConsumerPolicy: Coalesce})
+	defer unsubscribe()
+
+	w.handleBatch(context.Background(), []fsnotify.Event{{Name: "/root/a", Op: fsnotify.Create}}, nil)
+	w.handleBatch(context.Background(), []fsnotify.Event{{Name: "/root/a", Op: fsnotify.Remove}, {Name: "/root/b", Op: fsnotify.Write}}, nil)
+
+	batch := <-ch
+	assert.Len(t, batch.Events, 3)
+	assert.Equal(t, ChangeSet{{Path: "/root/b", Type: Modified}}, batch.Changes)
+}
+
+func TestUnsubscribeUnblocksSend(t *testing.T) {
+	w := newTestWatcher(t, &WatcherOptions{Directories: []string{"/root"}})
+	_, unsubscribe := w.Subscribe(&SubscribeOptions{BufferSize: 1})
+
+	w.handleBatch(context.Background(), []fsnotify.Event{{Name: "/root/a", Op: fsnotify.Write}}, nil)
+	done := make(chan struct{})
+	go func() {
+		// the channel is full, so this blocks until we unsubscribe
+		w.handleBatch(context.Background(), []fsnotify.Event{{Name: "/root/b", Op: fsnotify.Write}}, nil)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	unsubscribe()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish was not unblocked by unsubscribe")
+	}
+}
+
+func TestCloseClosesSubscribers(t *testing.T) {
+	w := newTestWatcher(t, &WatcherOptions{Directories: []string{"/root"}})
+	ch, _ := w.Subscribe(nil)
+	assert.NoError(t, w.Close(context.Background()))
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestSubscribeAfterClose(t *testing.T) {
+	w := newTestWatcher(t, &WatcherOptions{Directories: []string{"/root"}})
+	assert.NoError(t, w.Close(context.Background()))
+	ch, unsubscribe := w.Subscribe(nil)
+	defer unsubscribe()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
@@ -0,0 +1,157 @@
+package filewatcher
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// after EndWrite, events for the written paths are suppressed for this period, to allow for backend latency
+const endWriteGrace = 100 * time.Millisecond
+
+// WriteToken identifies a write announced with BeginWrite
+type WriteToken struct {
+	paths []string
+	once  sync.Once
+}
+
+// selfWrites tracks the paths which the application is writing, so the resulting events can be suppressed
+type selfWrites struct {
+	clock Clock
+
+	lock sync.Mutex
+	// events for each path are suppressed until this time
+	until map[string]time.Time
+	// the number of writes in progress for each path
+	writing map[string]int
+}
+
+func newSelfWrites(clock Clock) *selfWrites {
+	return &selfWrites{
+		clock:   clock,
+		until:   make(map[string]time.Time),
+		writing: make(map[string]int),
+	}
+}
+
+// suppressUntil suppresses events for the path until the given time (or later, if it is already suppressed for longer)
+// expired entries are removed, so paths which never produce an event are not kept
+// must be called with the lock held
+func (s *selfWrites) suppressUntil(path string, until time.Time) {
+	s.prune()
+	if until.After(s.until[path]) {
+		s.until[path] = until
+	}
+}
+
+// prune removes the paths whose suppression has expired
+// must be called with the lock held
+func (s *selfWrites) prune() {
+	now := s.clock.Now()
+	for p, until := range s.until {
+		if now.After(until) {
+			delete(s.until, p)
+		}
+	}
+}
+
+// suppressed returns whether events for the path are currently suppressed
+func (s *selfWrites) suppressed(path string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.writing[path] > 0 {
+		return true
+	}
+	until, ok := s.until[path]
+	if !ok {
+		return false
+	}
+	if s.clock.Now().After(until) {
+		delete(s.until, path)
+		return false
+	}
+	return true
+}
+
+// suppressSelfWrite returns whether the event is for a path which the application is writing, in which case the
+// event is dropped
+func (w *FileWatcher) suppressSelfWrite(ev fsnotify.Event) bool {
+	if !w.selfWrites.suppressed(ev.Name) {
+		return false
+	}
+	log.Printf("[TRACE] ignore self write %v", ev)
+	w.journalFilter(ev, "self write")
+	w.stats.eventSuppressed()
+	// keep the known state up to date, so the write is not reported by a resync
+	if ev.Op&w.eventMask != 0 {
+		w.updateKnownState([]fsnotify.Event{ev})
+	}
+	return true
+}
+
+// Suppress ignores events for the path which are received within the window
+//
+// Call Suppress before writing to a watched file, so the write does not come back as a change event.
+// Events are checked when they are received from the backend, or when a new file is found by polling, so events
+// which are suppressed never reach a batch.
+// Suppressed events still update the content hashes used by IgnoreUnchangedContent.
+func (w *FileWatcher) Suppress(path string, window time.Duration) {
+	s := w.selfWrites
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.suppressUntil(filepath.Clean(path), s.clock.Now().Add(window))
+}
+
+// BeginWrite ignores events for the paths until EndWrite is called with the returned token
+// (and for a short grace period afterwards, as events may be received after the write has completed)
+//
+// Writes may be nested - events for a path are suppressed until all writes to it have ended.
+// In WatchFiles mode, a new file is not found until the next poll, so the grace period for a file which is not
+// yet watched includes the poll interval.
+func (w *FileWatcher) BeginWrite(paths ...string) *WriteToken {
+	token := &WriteToken{}
+	s := w.selfWrites
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		token.paths = append(token.paths, p)
+		s.writing[p]++
+	}
+	return token
+}
+
+// EndWrite ends a write started with BeginWrite
+// calling EndWrite more than once for a token has no effect
+func (w *FileWatcher) EndWrite(token *WriteToken) {
+	token.once.Do(func() {
+		// a file which is not watched is found by the next poll, which raises its create event
+		unwatched := make(map[string]bool)
+		if w.watchMode == WatchFiles {
+			w.dirLock.Lock()
+			for _, p := range token.paths {
+				unwatched[p] = !w.watches[p]
+			}
+			w.dirLock.Unlock()
+		}
+
+		s := w.selfWrites
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		until := s.clock.Now().Add(endWriteGrace)
+		for _, p := range token.paths {
+			if s.writing[p]--; s.writing[p] <= 0 {
+				delete(s.writing, p)
+			}
+			if unwatched[p] {
+				s.suppressUntil(p, until.Add(w.pollInterval))
+				continue
+			}
+			s.suppressUntil(p, until)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package filewatcher_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/go-kit/filewatcher"
+)
+
+func TestSuppress(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.sp")
+	b := filepath.Join(dir, "b.sp")
+	c := filepath.Join(dir, "c.sp")
+
+	// (in WatchFiles mode, the grace period for unwatched files includes the poll interval)
+	w := newFakeWatcher(t, filewatcher.WatcherOptions{
+		Directories: []string{dir},
+		Include:     []string{"*.sp"},
+		WatchMode:   filewatcher.WatchDirectories,
+	})
+
+	w.Suppress(a, time.Hour)
+	token := w.BeginWrite(b)
+	nested := w.BeginWrite(b)
+	w.backend.Send(write(a), write(b), write(c))
+	w.clock.Advance(time.Second)
+	assert.Equal(t, [][]fsnotify.Event{{write(c)}}, w.batches)
+
+	// the write is in progress until all tokens have ended, then for the grace period
+	w.EndWrite(token)
+	w.EndWrite(token)
+	w.backend.Send(write(b))
+	w.EndWrite(nested)
+	w.backend.Send(write(b))
+	w.clock.Advance(time.Second)
+	w.backend.Send(write(b))
+	w.clock.Advance(time.Second)
+	assert.Equal(t, [][]fsnotify.Event{{write(c)}, {write(b)}}, w.batches)
+
+	assert.Equal(t, uint64(4), w.Stats().EventsSuppressed)
+}
+
+func TestSuppressCreatedFile(t *testing.T) {
+	dir := t.TempDir()
+	lock := filepath.Join(dir, "app.lock")
+	other := filepath.Join(dir, "other.lock")
+
+	w := newFakeWatcher(t, filewatcher.WatcherOptions{
+		Directories: []string{dir},
+		Include:     []string{"*.lock"},
+	})
+
+	// the file is not found until the next poll, after the write has ended
+	token := w.BeginWrite(lock)
+	assert.NoError(t, os.WriteFile(lock, []byte("lock"), 0644))
+	w.EndWrite(token)
+	assert.NoError(t, os.WriteFile(other, []byte("other"), 0644))
+	w.clock.Advance(4 * time.Second)
+	// wait for the poll to set the debounce timer, and schedule the next poll
+	w.clock.BlockUntil(2)
+	w.clock.Advance(time.Second)
+	assert.Equal(t, [][]fsnotify.Event{{{Name: other, Op: fsnotify.Create}}}, w.batches)
+	assert.True(t, w.backend.IsWatched(lock))
+	assert.Equal(t, uint64(1), w.Stats().EventsSuppressed)
+}
+
+func TestSuppressPrunesExpiredPaths(t *testing.T) {
+	w := newFakeWatcher(t, filewatcher.WatcherOptions{Directories: []string{t.TempDir()}})
+	w.Suppress("a", 0)
+	w.Suppress("b", time.Hour)
+	w.clock.Advance(time.Millisecond)
+
+	// paths which are not written are removed once they have expired
+	w.Suppress("c", time.Hour)
+	assert.Equal(t, []string{"b", "c"}, w.SuppressedPaths())
+}